@@ -1 +1,204 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"multi-app-relay-service/pkg/api"
+	"multi-app-relay-service/pkg/app"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// envOr returns the value of the environment variable name, or def if it is not set.
+func envOr(name string, def string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// envIntOr returns the integer value of the environment variable name, or def if it is
+// not set or not a number.
+func envIntOr(name string, def int) int {
+	value := envOr(name, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Ignoring %s=%q, not a number\n", name, value)
+		return def
+	}
+	return n
+}
+
+// envDurationOr returns the duration in the environment variable name, or def if it is
+// not set or not a duration.
+func envDurationOr(name string, def time.Duration) time.Duration {
+	value := envOr(name, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Ignoring %s=%q, not a duration\n", name, value)
+		return def
+	}
+	return d
+}
+
+// defaultListenAddr listens on the port Databricks Apps hands us, if any.
+func defaultListenAddr() string {
+	if port := os.Getenv("DATABRICKS_APP_PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8000"
+}
+
+// localURL returns the URL to reach a listen address such as ":8000" from this host.
+func localURL(listenAddr string) (string, string) {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "http://" + listenAddr, listenAddr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	hostPort := net.JoinHostPort(host, port)
+	return "http://" + hostPort, hostPort
+}
+
+// validateConfig checks the config file and prints every problem found in it, for use
+// in CI before deploying. It returns the process exit code.
+func validateConfig(filename string) int {
+	yamlFile, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	_, err = app.ParseConfig(yamlFile)
+	var validationErrors app.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, validationError := range validationErrors {
+			fmt.Printf("%s:%d: %s\n", filename, validationError.Line, validationError.Detail())
+		}
+		fmt.Printf("%s: %d problem(s) found\n", filename, len(validationErrors))
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("%s: %v\n", filename, err)
+		return 1
+	}
+	fmt.Printf("%s: config is valid\n", filename)
+	return 0
+}
+
+func main() {
+	configFile := envOr("MULTI_APP_CONFIG", "multi-app.yaml")
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if len(os.Args) > 2 {
+			configFile = os.Args[2]
+		}
+		os.Exit(validateConfig(configFile))
+	}
+
+	options := app.DefaultManagerOptions()
+	flag.StringVar(&configFile, "config", configFile, "config file to load, env MULTI_APP_CONFIG")
+	listenAddr := flag.String("listen", envOr("MULTI_APP_LISTEN_ADDR", defaultListenAddr()), "address the relay listens on, env MULTI_APP_LISTEN_ADDR")
+	flag.IntVar(&options.BasePort, "base-port", envIntOr("MULTI_APP_BASE_PORT", options.BasePort), "first port handed out to apps, env MULTI_APP_BASE_PORT")
+	flag.IntVar(&options.MaxPort, "max-port", envIntOr("MULTI_APP_MAX_PORT", options.MaxPort), "last port handed out to apps, env MULTI_APP_MAX_PORT")
+	flag.IntVar(&options.ManagementPort, "management-port", envIntOr("MULTI_APP_MANAGEMENT_PORT", options.ManagementPort), "port of the management ui, env MULTI_APP_MANAGEMENT_PORT")
+	flag.IntVar(&options.AppLimit, "app-limit", envIntOr("MULTI_APP_LIMIT", options.AppLimit), "maximum number of apps running at once, 0 to only limit them by the memory and cpus they declare, env MULTI_APP_LIMIT")
+	flag.BoolVar(&options.Cgroups, "cgroups", envOr("MULTI_APP_CGROUPS", "") == "true", "run every app in its own cgroup v2, so stopping it kills everything it started, env MULTI_APP_CGROUPS=true")
+	shutdownTimeout := flag.Duration("shutdown-timeout", envDurationOr("MULTI_APP_SHUTDOWN_TIMEOUT", 20*time.Second), "total time given to in-flight requests, at most half of it, and then to the apps to finish when stopping; keep it below the platform's grace period, env MULTI_APP_SHUTDOWN_TIMEOUT")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s validate [config file]\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if options.AppLimit < 0 {
+		fmt.Println("app-limit must not be negative")
+		os.Exit(2)
+	}
+	if options.BasePort < 1 || options.MaxPort > 65535 || options.BasePort > options.MaxPort {
+		fmt.Printf("invalid port range %d-%d\n", options.BasePort, options.MaxPort)
+		os.Exit(2)
+	}
+	relayURL, relayHost := localURL(*listenAddr)
+	options.RelayURL = relayURL
+
+	appManager, err := app.NewManagerFromYaml(configFile, options)
+	if err != nil {
+		panic(err)
+	}
+
+	err = appManager.StageCode() // Clone the repos
+	if err != nil {
+		panic(err)
+	}
+
+	err = appManager.StageUICode()
+	if err != nil {
+		panic(err)
+	}
+	err = appManager.RunManager.RunApp(appManager.GetManagementApp())
+	if err != nil {
+		panic(err)
+	}
+
+	stop := make(chan struct{})
+	err = appManager.WatchConfig(stop)
+	if err != nil {
+		fmt.Println("Error watching config, hot reload is disabled", err)
+	}
+	appManager.RunManager.ReapIdleApps(stop)
+
+	server := &http.Server{
+		Addr:    *listenAddr,
+		Handler: api.NewRouter(appManager, relayHost),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// the apps keep serving while requests drain, then they are stopped with the
+	// management ui last
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-quit:
+		fmt.Println("Received", sig, "shutting down")
+	case err := <-serverErr:
+		fmt.Println("Error serving", err)
+		exitCode = 1
+	}
+	appManager.RunManager.BeginShutdown()
+	close(stop)
+
+	// both phases share one deadline, so the relay is done before the platform kills it
+	// and orphans the apps; draining gets at most half, so the apps always get the rest
+	deadline := time.Now().Add(*shutdownTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(-*shutdownTimeout/2))
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		fmt.Println("Error draining requests", err)
+	}
+	err = appManager.Shutdown(time.Until(deadline))
+	if err != nil {
+		fmt.Println("Error stopping apps", err)
+		exitCode = 1
+	}
+	fmt.Println("Relay stopped")
+	os.Exit(exitCode)
+}
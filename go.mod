@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/ShinyTrinkets/overseer v0.6.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gin-gonic/gin v1.10.0
 	github.com/go-git/go-git/v5 v5.12.0
 	gopkg.in/yaml.v3 v3.0.1
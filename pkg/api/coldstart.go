@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"multi-app-relay-service/pkg/app"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// coldStartHold is how long a page load waits for an app to come up before the
+	// waiting page is served instead.
+	coldStartHold = 5 * time.Second
+	// coldStartTimeout is how long other requests, e.g. API calls, wait for an app to
+	// come up before they are turned away.
+	coldStartTimeout = 60 * time.Second
+)
+
+var waitingPage = template.Must(template.New("waiting").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Starting {{.Name}}</title>
+<noscript><meta http-equiv="refresh" content="2"></noscript>
+<style>
+body { font-family: sans-serif; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; color: #333; }
+main { text-align: center; }
+#error { color: #b00020; }
+</style>
+</head>
+<body>
+<main>
+<h2>Starting {{.Name}}&hellip;</h2>
+<p id="status">Status: {{.Status}}</p>
+<p id="error"></p>
+<p><a href="/relay/{{.Name}}/_logz">App logs</a></p>
+</main>
+<script>
+const statusUrl = {{.StatusURL}};
+async function poll() {
+  try {
+    const response = await fetch(statusUrl, {cache: "no-store"});
+    const info = await response.json();
+    document.getElementById("status").textContent = "Status: " + info.status;
+    if (info.status === "ready" || info.status === "unhealthy") {
+      location.reload();
+      return;
+    }
+    if (info.status === "failed" || info.status === "terminated") {
+      document.getElementById("error").textContent =
+        "The app stopped" + (info.lastError ? ": " + info.lastError : "") + ". Reload the page to try again.";
+      return;
+    }
+  } catch (e) {
+    // the relay may be restarting, keep polling
+  }
+  setTimeout(poll, 1000);
+}
+setTimeout(poll, 1000);
+</script>
+</body>
+</html>
+`))
+
+// wantsHTML reports whether the request is a page load by a browser, rather than an API
+// call or a websocket.
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.Header.Get("Upgrade") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// waitReady waits until the app is ready, has stopped or the timeout passes.
+func waitReady(thisApp *app.App, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		status := thisApp.GetStatus()
+		if thisApp.IsReady() || status == app.StatusUnhealthy || status.IsStopped() {
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// coldStart starts an app with autoStart on behalf of a request and waits for it to be
+// ready. It reports whether the request can go on to the app; if not, it has already
+// been answered, with the waiting page for page loads.
+func coldStart(manager *app.Manager, thisApp *app.App, c *gin.Context) bool {
+	if thisApp.GetStatus().IsStopped() {
+		fmt.Println("Starting app", thisApp.Name, "for its first request")
+		err := manager.StartApp(thisApp)
+		// a concurrent request may have started it first
+		if err != nil && thisApp.GetStatus().IsStopped() {
+			c.JSON(503, gin.H{
+				"message": "App could not be started: " + err.Error(),
+			})
+			return false
+		}
+	}
+
+	browser := wantsHTML(c.Request)
+	timeout := coldStartTimeout
+	if browser {
+		timeout = coldStartHold
+	}
+	waitReady(thisApp, timeout)
+	if thisApp.IsReady() {
+		return true
+	}
+
+	c.Header("Retry-After", "2")
+	c.Header("Cache-Control", "no-store")
+	if browser {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(503)
+		err := waitingPage.Execute(c.Writer, gin.H{
+			"Name":      thisApp.Name,
+			"Status":    thisApp.GetStatus(),
+			"StatusURL": "/" + thisApp.Name + "/status",
+		})
+		if err != nil {
+			fmt.Println("Error rendering waiting page", err)
+		}
+		return false
+	}
+	info := thisApp.Info()
+	message := "App is starting. Please try again shortly"
+	if info.Status == app.StatusQueued {
+		message = "App is queued to start once there is room. Please try again shortly"
+	}
+	if info.Status.IsStopped() {
+		message = "App stopped while starting: " + info.LastError
+	}
+	c.JSON(503, gin.H{
+		"message": message,
+		"status":  info.Status,
+	})
+	return false
+}
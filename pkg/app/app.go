@@ -9,21 +9,79 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// stopGracePeriod is how long an app gets to exit after SIGTERM before it is killed.
+	stopGracePeriod = 10 * time.Second
+	// stopTimeout bounds how long Stop waits for the app's run to finish once killed.
+	stopTimeout = 15 * time.Second
+	// portReleaseTimeout bounds how long Stop waits for the app's port to be released.
+	portReleaseTimeout = 5 * time.Second
+	// launchTimeout bounds how long Stop waits for the supervisor to start a command it
+	// was just given.
+	launchTimeout = 1 * time.Second
 )
 
 type App struct {
 	ID            string           // Unique identifier for the app
 	Name          string           // Human-readable name
-	Type          Type             // Type of app, e.g., "python" or "nodejs"
+	Type          Type             // Type of app, e.g., "python", "nodejs", "r" or "command"
 	RootDir       string           // Root directory of the app
 	Command       []string         // Command(s) to start the app
+	ShellCommand  string           // Set when the command was given as a shell string, run with bash -c
 	Supervisor    *cmd.Overseer    // Pointer to the Supervisor struct
 	LogChan       chan *cmd.LogMsg // Channel to receive log messages
 	Status        Status           // Status of the app, e.g., "running", "stopped"
 	LogLines      *LineBuffer      // Buffer to store log lines
 	PreferredPort int              // Preferred port for the app
+	HealthCheck   *HealthCheck     // Readiness probe, defaults to a TCP check on PreferredPort
+	RestartPolicy *RestartPolicy   // What to do when the app exits on its own, nil never restarts
+	Python        *PythonConfig    // Python specific settings, only used by python apps
+	Env           []EnvVar         // Environment variables set for the app
+	EnvFrom       []EnvSource      // Sources of environment variables, applied before Env
+	RestartCount  int              // Number of automatic restarts since the app was last started
+	LastExitCode  int              // Exit code of the last app process, -1 if it was signaled
+	LastError     string           // Why the app last failed, e.g. the failed setup step or exit signal
+	StartedAt     time.Time        // When the app was last started
+	StoppedAt     time.Time        // When the app last stopped, zero while it is running
+	CgroupParent  string           // Directory to create the app's cgroup in, empty to run without one
+	IdleTimeout   time.Duration    // Stop the app after this long without requests, zero to keep it running
+	AutoStart     bool             // Start the app when a request comes in for it while it is stopped
+	Priority      int              // Apps are only evicted to make room for apps of the same or higher priority
+	Pinned        bool             // Never evict the app to make room for another
+	Resources     *Resources       // Memory and CPUs the app needs, nil if it did not declare any
+	LastRequestAt time.Time        // When a request was last relayed to the app
+
+	onExit          func(a *App)        // Called when the app process exits, set by the RunManager
+	stopRequested   bool                // Set when Stop was called, so the exit is not treated as a crash
+	rebuild         bool                // Set to throw away cached dependencies on the next start
+	done            chan struct{}       // Closed once the current run of the app has fully finished
+	resolvedEnv     []string            // Environment built from Env and EnvFrom when the app starts
+	commands        map[string]*cmd.Cmd // Commands the supervisor is running right now, by ID
+	cgroup          *Cgroup             // Cgroup the app runs in, nil if cgroups are not used
+	inFlight        int                 // Number of requests being relayed to the app right now
+	launching       bool                // Set from when the RunManager makes room for the app until it is started
+	lastActivity    time.Time           // When the app was launched or last had a request, for the idle timeout
+	readyAt         time.Time           // When the current run last became ready, zero while it is not
+	cgroupLimited   bool                // Set when the kernel enforces the app's memory limit through its cgroup
+	memoryUsageMB   int                 // Resident memory of the app's processes when last sampled
+	overMemoryLimit bool                // Set when the app is killed for going over its memory limit
+	mutex           sync.Mutex          // Mutex for concurrency control
+}
 
-	mutex sync.Mutex // Mutex for concurrency control
+// AppInfo is a point in time snapshot of an app's lifecycle, as reported by /apps.
+type AppInfo struct {
+	Status        Status     `json:"status"`
+	RestartCount  int        `json:"restartCount"`
+	LastExitCode  int        `json:"lastExitCode"`
+	LastError     string     `json:"lastError,omitempty"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	StoppedAt     *time.Time `json:"stoppedAt,omitempty"`
+	LastRequestAt *time.Time `json:"lastRequestAt,omitempty"`
+	MemoryUsageMB int        `json:"memoryUsageMB,omitempty"`
 }
 
 type PythonVenv struct {
@@ -52,6 +110,7 @@ type LineBuffer struct {
 	buffer   bytes.Buffer
 	lines    int
 	maxLines int
+	mutex    sync.Mutex
 }
 
 // NewLineBuffer initializes a LineBuffer with a specified max number of lines.
@@ -63,6 +122,8 @@ func NewLineBuffer(maxLines int) *LineBuffer {
 
 // Append adds a new string to the buffer.
 func (lb *LineBuffer) Append(s string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 	lines := strings.Split(s, "\n")
 	for _, line := range lines {
 		if lb.lines >= lb.maxLines {
@@ -84,6 +145,8 @@ func (lb *LineBuffer) trimOldestLine() {
 
 // String returns the content of the buffer as a string.
 func (lb *LineBuffer) String() string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 	return lb.buffer.String()
 }
 
@@ -123,21 +186,96 @@ func NewApp(id, rootDir, name string,
 	}
 }
 
+// newSuperVisor gives the app a new supervisor. The caller holds a.mutex.
 func (a *App) newSuperVisor() {
 	a.Supervisor = cmd.NewOverseer()
 	a.Supervisor.WatchLogs(a.LogChan)
 }
 
+// supervisor returns the app's supervisor, nil from the moment the app is stopped until
+// it starts again.
+func (a *App) supervisor() *cmd.Overseer {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.Supervisor
+}
+
 func (a *App) isPython() bool {
 	return a.Type == TypePython
 }
 
+// hasFile reports whether the given file exists in the app's root directory.
+func (a *App) hasFile(name string) bool {
+	_, err := os.Stat(filepath.Join(a.RootDir, name))
+	return err == nil
+}
+
 func (a *App) UpdateStatus(status Status) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	a.Status = status
 }
 
+// transitionStatus moves the app to status only if it is currently in one of from.
+func (a *App) transitionStatus(status Status, from ...Status) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, s := range from {
+		if a.Status == s {
+			a.Status = status
+			return true
+		}
+	}
+	return false
+}
+
+// GetStatus returns the current status of the app.
+func (a *App) GetStatus() Status {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.Status
+}
+
+// Info returns a snapshot of the app's lifecycle.
+func (a *App) Info() AppInfo {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	info := AppInfo{
+		Status:       a.Status,
+		RestartCount: a.RestartCount,
+		LastExitCode: a.LastExitCode,
+		LastError:    a.LastError,
+	}
+	if !a.StartedAt.IsZero() {
+		startedAt := a.StartedAt
+		info.StartedAt = &startedAt
+	}
+	if !a.StoppedAt.IsZero() {
+		stoppedAt := a.StoppedAt
+		info.StoppedAt = &stoppedAt
+	}
+	if !a.LastRequestAt.IsZero() {
+		lastRequestAt := a.LastRequestAt
+		info.LastRequestAt = &lastRequestAt
+	}
+	if !a.Status.IsStopped() {
+		info.MemoryUsageMB = a.memoryUsageMB
+	}
+	return info
+}
+
+// StopRequested reports whether the app was deliberately stopped.
+func (a *App) StopRequested() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.stopRequested
+}
+
+// IsReady reports whether the app passed its health check and can be proxied to.
+func (a *App) IsReady() bool {
+	return a.GetStatus() == StatusReady
+}
+
 func (a *App) pythonVenvPath() *PythonVenv {
 	venvDir := filepath.Join(a.RootDir, ".venv")
 	pythonBinPath := filepath.Join(venvDir, "bin")
@@ -149,51 +287,179 @@ func (a *App) pythonVenvPath() *PythonVenv {
 	}
 }
 
-func (a *App) pythonCmdOptions() cmd.Options {
-	path := os.Getenv("PATH")
+// portEnv returns the port variables every app receives, regardless of its type.
+func (a *App) portEnv() []string {
+	return []string{
+		"MULTI_APP_PORT=" + fmt.Sprintf("%d", a.PreferredPort),
+		"PORT=" + fmt.Sprintf("%d", a.PreferredPort),
+	}
+}
+
+// cmdOptions returns the supervisor options shared by every app type. The given runtime
+// variables are layered over the app's configured environment, and the port variables
+// over everything else.
+func (a *App) cmdOptions(env ...string) cmd.Options {
 	return cmd.Options{
 		Buffered:  false,
 		Streaming: true,
 		Dir:       a.RootDir,
-		Env: []string{
-			"PATH=" + a.pythonVenvPath().PythonBinPath + ":" + path,
-			"MULTI_APP_PORT=" + fmt.Sprintf("%d", a.PreferredPort),
-			"PORT=" + fmt.Sprintf("%d", a.PreferredPort),
-			"GRADIO_SERVER_PORT=" + fmt.Sprintf("%d", a.PreferredPort),
-			"STREAMLIT_SERVER_PORT=" + fmt.Sprintf("%d", a.PreferredPort),
-		},
+		Env:       append(append(a.environment(), env...), a.portEnv()...),
 	}
 }
 
-func (a *App) makeVenv() error {
-	if !a.isPython() {
-		return nil
+func (a *App) pythonCmdOptions() cmd.Options {
+	path := os.Getenv("PATH")
+	return a.cmdOptions(
+		// the same as sourcing bin/activate, so commands can run without a shell
+		"VIRTUAL_ENV="+a.pythonVenvPath().VenvDir,
+		"PATH="+a.pythonVenvPath().PythonBinPath+":"+path,
+		"GRADIO_SERVER_PORT="+fmt.Sprintf("%d", a.PreferredPort),
+		"STREAMLIT_SERVER_PORT="+fmt.Sprintf("%d", a.PreferredPort),
+	)
+}
+
+// runSetupStep runs a single supervised command to completion, e.g. a dependency install,
+// and fails if it did not exit cleanly. The step's output is framed by marker lines in the
+// app logs so a failing step can be told apart from the rest.
+func (a *App) runSetupStep(id string, exe string, args []string, cmdOptions cmd.Options) error {
+	// Stop drops a.Supervisor while we are still waiting on it
+	supervisor := a.supervisor()
+	if supervisor == nil || a.StopRequested() {
+		return fmt.Errorf("app was stopped before %s", id)
 	}
-	fmt.Println("Setting up Python venv")
-	venv := a.pythonVenvPath()
+	a.LogLines.Append(fmt.Sprintf("==> [%s] %s %s", id, exe, strings.Join(args, " ")))
+	command := supervisor.Add(id, exe, args, cmdOptions)
+	if command == nil {
+		return fmt.Errorf("could not add setup step %s", id)
+	}
+	if !a.track(id, command) {
+		supervisor.Remove(id)
+		return fmt.Errorf("app was stopped before %s", id)
+	}
+	// Supervise, unlike SuperviseAll, leaves SIGINT and SIGTERM to the relay's shutdown
+	supervisor.Supervise(id)
+	a.untrack(id)
+	err := processError(supervisor.Status(id))
+	supervisor.Remove(id)
+	if err != nil {
+		a.LogLines.Append(fmt.Sprintf("<== [%s] failed: %v", id, err))
+		return fmt.Errorf("%s failed: %v", id, err)
+	}
+	a.LogLines.Append(fmt.Sprintf("<== [%s] done", id))
+	return nil
+}
 
-	cmdOptions := a.pythonCmdOptions()
-	fmt.Println("Command options", cmdOptions)
-	a.Supervisor.Add("setupVenv", "python", []string{"-m", "venv", venv.VenvDir},
-		cmdOptions)
-	a.Supervisor.SuperviseAll()
-	a.Supervisor.Remove("setupVenv")
+// processError turns the final state of a supervised process into an error, nil if it
+// exited cleanly.
+func processError(state *cmd.ProcessJSON) error {
+	switch {
+	case state.Error != nil:
+		// the process could not be started or was killed by a signal
+		return state.Error
+	case state.ExitCode != 0:
+		return fmt.Errorf("exited with code %d", state.ExitCode)
+	}
+	return nil
+}
+
+// commandLine returns the executable and arguments that run the app. Shell commands run
+// through /bin/bash -c, exec form commands are resolved against the app's own PATH.
+func (a *App) commandLine(env []string) (string, []string, error) {
+	if a.ShellCommand != "" {
+		return "/bin/bash", []string{"-c", a.ShellCommand}, nil
+	}
+	if len(a.Command) == 0 {
+		return "", nil, errors.New("no command to run")
+	}
+	exe, err := lookPathIn(a.Command[0], env)
+	if err != nil {
+		return "", nil, err
+	}
+	return exe, a.Command[1:], nil
+}
+
+// launch runs the app command under the supervisor and blocks until it exits.
+// The app only becomes ready once its health check passes.
+func (a *App) launch(cmdOptions cmd.Options) error {
+	exe, args, err := a.commandLine(cmdOptions.Env)
+	if err != nil {
+		return err
+	}
+	if a.cgroup != nil {
+		exe, args = a.cgroup.wrap(exe, args)
+	}
+	// Stop drops a.Supervisor while we are still waiting on it
+	supervisor := a.supervisor()
+	if supervisor == nil || !a.transitionStatus(StatusRunning, StatusStarting, StatusSetup) {
+		return fmt.Errorf("app was stopped before it was launched")
+	}
+	// the idle timeout counts from the launch, so a long setup does not count against it
+	a.mutex.Lock()
+	a.lastActivity = time.Now()
+	a.mutex.Unlock()
+	command := supervisor.Add(a.ID, exe, args, cmdOptions)
+	if command == nil {
+		return fmt.Errorf("could not add app command %s", exe)
+	}
+	if !a.track(a.ID, command) {
+		supervisor.Remove(a.ID)
+		return fmt.Errorf("app was stopped before it was launched")
+	}
+	done := make(chan struct{})
+	go a.monitorHealth(done)
+	go a.watchMemory(done)
+	supervisor.Supervise(a.ID)
+	close(done)
+	a.untrack(a.ID)
+
+	state := supervisor.Status(a.ID)
+	// a restart adds the command again, which fails while it is still registered
+	supervisor.Remove(a.ID)
+	if state.StartTime.Unix() > 0 {
+		a.mutex.Lock()
+		a.StartedAt = state.StartTime
+		a.mutex.Unlock()
+	}
+	a.finish(state.ExitCode, processError(state))
 	return nil
 }
 
-func (a *App) installRequirementsTxt() error {
+// finish records why the app stopped and hands it to the exit handler. Errors of apps
+// that were deliberately stopped are not failures.
+func (a *App) finish(exitCode int, err error) {
+	a.mutex.Lock()
+	a.LastExitCode = exitCode
+	a.StoppedAt = time.Now()
+	a.LastError = ""
+	switch {
+	case a.stopRequested:
+		// Stop reports the status once the whole app is gone
+	case a.overMemoryLimit:
+		a.LastError = fmt.Sprintf("killed for using more than its memory limit of %d MB", a.memoryLimitMB())
+		a.Status = StatusFailed
+	case err != nil:
+		a.LastError = err.Error()
+		a.Status = StatusFailed
+	default:
+		a.Status = StatusTerminated
+	}
+	onExit := a.onExit
+	a.mutex.Unlock()
+	if onExit != nil {
+		onExit(a)
+	}
+}
+
+func (a *App) makeVenv() error {
 	if !a.isPython() {
 		return nil
 	}
-	fmt.Println("Installing requirements.txt")
+	fmt.Println("Setting up Python venv")
+	venv := a.pythonVenvPath()
+
 	cmdOptions := a.pythonCmdOptions()
-	commandStr := fmt.Sprintf("pip install -r %s/requirements.txt", a.RootDir)
-	sourcedCmd := fmt.Sprintf("source %s && %s", a.pythonVenvPath().ActivatePath, commandStr)
-	a.Supervisor.Add("installRequirements", "/bin/bash", []string{"-c", sourcedCmd},
+	return a.runSetupStep("setupVenv", a.Python.InterpreterName(), []string{"-m", "venv", venv.VenvDir},
 		cmdOptions)
-	a.Supervisor.SuperviseAll()
-	a.Supervisor.Remove("installRequirements")
-	return nil
 }
 
 func (a *App) showPythonExeLocation() error {
@@ -202,11 +468,8 @@ func (a *App) showPythonExeLocation() error {
 	}
 	fmt.Println("Displaying python executable location")
 	cmdOptions := a.pythonCmdOptions()
-	a.Supervisor.Add("locatePython", "python", []string{"-c", "import sys; print(sys.executable)"},
+	return a.runSetupStep("locatePython", "python", []string{"-c", "import sys; print(sys.executable)"},
 		cmdOptions)
-	a.Supervisor.SuperviseAll()
-	a.Supervisor.Remove("locatePython")
-	return nil
 }
 
 func (a *App) pipList() error {
@@ -217,11 +480,8 @@ func (a *App) pipList() error {
 	cmdOptions := a.pythonCmdOptions()
 	commandStr := "pip list"
 	sourcedCmd := fmt.Sprintf("source %s && %s", a.pythonVenvPath().ActivatePath, commandStr)
-	a.Supervisor.Add("showPackages", "/bin/bash", []string{"-c", sourcedCmd},
+	return a.runSetupStep("showPackages", "/bin/bash", []string{"-c", sourcedCmd},
 		cmdOptions)
-	a.Supervisor.SuperviseAll()
-	a.Supervisor.Remove("showPackages")
-	return nil
 }
 
 func (a *App) setupPython() error {
@@ -229,15 +489,8 @@ func (a *App) setupPython() error {
 		return nil
 	}
 	fmt.Println("Setting up Python app")
-	err := a.makeVenv()
+	err := a.buildVenv()
 	if err != nil {
-		fmt.Println("Error setting up venv")
-		return err
-	}
-	fmt.Println("Setting up requirements.txt")
-	err = a.installRequirementsTxt()
-	if err != nil {
-		fmt.Println("Error installing requirements.txt")
 		return err
 	}
 	fmt.Println("Showing python executable location")
@@ -257,9 +510,16 @@ func (a *App) setupPython() error {
 
 func (a *App) setup() error {
 	fmt.Println("Setting up app")
-	a.UpdateStatus(StatusSetup)
-	if a.isPython() {
+	if !a.transitionStatus(StatusSetup, StatusStarting) {
+		return fmt.Errorf("app was stopped before setup")
+	}
+	switch a.Type {
+	case TypePython:
 		return a.setupPython()
+	case TypeNodejs:
+		return a.setupNodejs()
+	case TypeR:
+		return a.setupR()
 	}
 	return nil
 }
@@ -275,35 +535,71 @@ func (a *App) startPython() error {
 	}
 	fmt.Println("Starting app command")
 	cmdOptions := a.pythonCmdOptions()
-	a.UpdateStatus(StatusRunning)
-	commandStr := strings.Join(a.Command, " ")
-	sourcedCmd := fmt.Sprintf("source %s && %s", a.pythonVenvPath().ActivatePath, commandStr)
-	a.Supervisor.Add(a.ID, "/bin/bash", []string{"-c", sourcedCmd},
-		cmdOptions)
-	a.Supervisor.SuperviseAll()
-	a.UpdateStatus(StatusTerminated)
+	return a.launch(cmdOptions)
+}
+
+// run sets up and runs the app according to its type, blocking until it exits.
+func (a *App) run() error {
+	switch a.Type {
+	case TypePython:
+		return a.startPython()
+	case TypeNodejs:
+		return a.startNodejs()
+	case TypeR:
+		return a.startR()
+	case TypeCommand:
+		return a.startCommand()
+	}
 	return nil
 }
 
 func (a *App) Start() error {
-	if a.Status != StatusTerminated {
+	if !a.GetStatus().IsStopped() {
 		return errors.New("app is already running or starting to run")
 	}
-	if a.Supervisor == nil {
-		a.newSuperVisor()
+	if !PortFree(a.PreferredPort) {
+		return fmt.Errorf("port %d is already in use by another process", a.PreferredPort)
 	}
-	err := KillPort(a.PreferredPort)
-	if err != nil {
-		fmt.Println("Error killing port", err)
+	if a.CgroupParent != "" && a.cgroup == nil {
+		cgroup, err := NewCgroup(a.CgroupParent, a.ID)
+		if err != nil {
+			fmt.Println("Error creating cgroup, running without it", err)
+		}
+		a.cgroup = cgroup
+	}
+	cgroupLimited := false
+	if a.cgroup != nil && a.Resources != nil {
+		err := a.cgroup.SetLimits(a.Resources)
+		if err != nil {
+			fmt.Println("Error setting cgroup limits, falling back to watching the app's memory", err)
+		}
+		cgroupLimited = err == nil && a.Resources.MemoryMB > 0
 	}
 	fmt.Println("Starting app")
-	a.UpdateStatus(StatusStarting)
+	a.mutex.Lock()
+	a.cgroupLimited = cgroupLimited
+	if a.Supervisor == nil {
+		a.newSuperVisor()
+	}
+	a.stopRequested = false
+	a.overMemoryLimit = false
+	a.memoryUsageMB = 0
+	a.Status = StatusStarting
+	a.StartedAt = time.Now()
+	a.StoppedAt = time.Time{}
+	a.readyAt = time.Time{}
+	done := make(chan struct{})
+	a.done = done
+	a.mutex.Unlock()
 	go func() {
-		if a.isPython() {
-			err := a.startPython()
-			if err != nil {
-				return
-			}
+		defer close(done)
+		err := a.resolveEnv()
+		if err == nil {
+			err = a.run()
+		}
+		if err != nil {
+			fmt.Println("Error starting app", err)
+			a.finish(0, err)
 		}
 	}()
 	return nil
@@ -313,17 +609,203 @@ func (a *App) Logs() string {
 	return a.LogLines.String()
 }
 
+// Stop terminates the app: SIGTERM to its process group and to any of its processes
+// that left the group, then SIGKILL to whatever is left after the grace period. The app
+// is only reported as terminated once its port is released; if the port stays taken,
+// the app is marked as failed.
 func (a *App) Stop() {
 	fmt.Println("Stopping app")
-	for _, supervisedApp := range a.Supervisor.ListAll() {
-		err := a.Supervisor.Stop(supervisedApp)
-		if err != nil {
-			fmt.Println("Error stopping app", err)
+	a.mutex.Lock()
+	a.stopRequested = true
+	done := a.done
+	if !a.Status.IsStopped() {
+		a.Status = StatusStopping
+	}
+	supervisor := a.Supervisor
+	a.mutex.Unlock()
+	// the supervisor starts a command a moment after it is added, and stopping it before
+	// then does nothing
+	a.waitLaunched(launchTimeout)
+	// note the app's processes while their parents are still alive to link them up
+	roots := a.supervisedPIDs()
+	tree := a.processTree(roots)
+	if a.cgroup != nil {
+		for _, pid := range a.cgroup.Processes() {
+			tree[pid] = true
 		}
-		a.Supervisor.Remove(supervisedApp)
 	}
-	a.Supervisor.StopAll(true)
-	a.Supervisor.UnWatchLogs(a.LogChan)
+	starts := processStarts(tree)
+	a.mutex.Lock()
 	a.Supervisor = nil
+	a.mutex.Unlock()
+
+	if supervisor != nil {
+		for _, supervisedApp := range supervisor.ListAll() {
+			err := supervisor.Stop(supervisedApp)
+			if err != nil {
+				fmt.Println("Error stopping app", err)
+			}
+		}
+		signalStrays(tree, roots, syscall.SIGTERM)
+	}
+	// wait for the current run to wind down, so it cannot overwrite the status of the next one
+	if !waitDone(done, stopGracePeriod) {
+		fmt.Println("App", a.Name, "did not stop within", stopGracePeriod, "killing it")
+		a.kill(roots, tree, starts)
+		if !waitDone(done, stopTimeout) {
+			fmt.Println("Timed out waiting for app to stop")
+		}
+	}
+	if supervisor != nil {
+		for _, supervisedApp := range supervisor.ListAll() {
+			supervisor.Remove(supervisedApp)
+		}
+		supervisor.UnWatchLogs(a.LogChan)
+	}
+	// workers can outlive the supervised process, and keep holding the port
+	a.kill(roots, tree, starts)
+	if supervisor == nil && len(tree) == 0 {
+		// the app was not running, whatever holds the port is not ours
+		a.UpdateStatus(StatusTerminated)
+		return
+	}
+	err := a.releasePort(tree)
+	if err != nil {
+		fmt.Println("Error releasing port", err)
+		a.mutex.Lock()
+		a.Status = StatusFailed
+		a.LastError = err.Error()
+		a.mutex.Unlock()
+		return
+	}
 	a.UpdateStatus(StatusTerminated)
 }
+
+// kill sends SIGKILL to the process groups of the roots, to every process of the tree
+// and to the app's cgroup. Processes that are gone, or whose PID was reused since their
+// start times were noted in starts, are left alone.
+func (a *App) kill(roots map[int]bool, tree map[int]bool, starts map[int]uint64) {
+	for root := range roots {
+		// while the leader is alive, its group cannot be reused
+		if sameProcess(root, starts) {
+			syscall.Kill(-root, syscall.SIGKILL)
+		}
+	}
+	for pid := range tree {
+		if sameProcess(pid, starts) {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	if a.cgroup != nil {
+		a.cgroup.Kill()
+	}
+}
+
+// waitDone waits for done to be closed, and reports whether it was within the timeout.
+// A nil channel counts as done.
+func waitDone(done chan struct{}, timeout time.Duration) bool {
+	if done == nil {
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// releasePort waits for the app's port to be released. Processes of the app's tree still
+// holding it are terminated; other processes are reported.
+func (a *App) releasePort(tree map[int]bool) error {
+	deadline := time.Now().Add(portReleaseTimeout)
+	for !PortFree(a.PreferredPort) {
+		if time.Now().After(deadline) {
+			err := KillPort(a.PreferredPort, tree)
+			if err != nil {
+				return err
+			}
+			if !PortFree(a.PreferredPort) {
+				return fmt.Errorf("port %d is still in use after stopping the app", a.PreferredPort)
+			}
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// track records a command the supervisor runs, until untrack. It refuses, and reports
+// false, once the app is being stopped.
+func (a *App) track(id string, command *cmd.Cmd) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.stopRequested {
+		return false
+	}
+	if a.commands == nil {
+		a.commands = make(map[string]*cmd.Cmd)
+	}
+	a.commands[id] = command
+	return true
+}
+
+func (a *App) untrack(id string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.commands, id)
+}
+
+// trackedCommands returns the commands the supervisor runs right now.
+func (a *App) trackedCommands() []*cmd.Cmd {
+	a.mutex.Lock()
+	commands := make([]*cmd.Cmd, 0, len(a.commands))
+	for _, command := range a.commands {
+		commands = append(commands, command)
+	}
+	a.mutex.Unlock()
+	return commands
+}
+
+// waitLaunched waits, at most for the timeout, until every command the supervisor was
+// given has been started.
+func (a *App) waitLaunched(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		launching := false
+		for _, command := range a.trackedCommands() {
+			if command.Status().PID == 0 {
+				launching = true
+			}
+		}
+		if !launching {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// supervisedPIDs returns the PIDs of the processes the app's supervisor is running. Each
+// of them leads its own process group.
+func (a *App) supervisedPIDs() map[int]bool {
+	pids := make(map[int]bool)
+	// the supervisor's own Status races with a command being started, the command's does not
+	for _, command := range a.trackedCommands() {
+		if pid := command.Status().PID; pid > 0 {
+			pids[pid] = true
+		}
+	}
+	return pids
+}
+
+// processTree returns the PIDs of the supervised processes, along with their
+// descendants, see processTree.
+func (a *App) processTree(roots map[int]bool) map[int]bool {
+	pids := make(map[int]bool)
+	for root := range roots {
+		for pid := range processTree(root) {
+			pids[pid] = true
+		}
+	}
+	return pids
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopAndStartAgain(t *testing.T) {
+	app := newTestApp(t, "stop-start", "sleep 30")
+	// reads the supervisor while it is swapped, as the memory watcher does
+	polling := make(chan struct{})
+	pollerDone := make(chan struct{})
+	go func() {
+		defer close(pollerDone)
+		for {
+			select {
+			case <-polling:
+				return
+			default:
+				app.supervisedPIDs()
+				app.Info()
+			}
+		}
+	}()
+	defer func() {
+		close(polling)
+		<-pollerDone
+	}()
+
+	for run := 0; run < 2; run++ {
+		err := app.Start()
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		waitFor(t, 5*time.Second, "the app to launch", func() bool {
+			return len(app.supervisedPIDs()) > 0
+		})
+		app.Stop()
+		if status := app.GetStatus(); status != StatusTerminated {
+			t.Fatalf("run %d: status after stop = %s, want terminated", run, status)
+		}
+		if pids := app.supervisedPIDs(); len(pids) != 0 {
+			t.Fatalf("run %d: supervised processes after stop: %v", run, pids)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupMount is where the cgroup v2 hierarchy is mounted.
+const cgroupMount = "/sys/fs/cgroup"
+
+// Cgroup is a cgroup v2 directory holding the processes of one app. Unlike a process
+// group, a process cannot leave it, so it catches workers that start their own session.
+type Cgroup struct {
+	Path string
+}
+
+// ownCgroup returns the cgroup v2 path of the relay, relative to cgroupMount.
+func ownCgroup() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupMount, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroupMount)
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("the relay is not in a cgroup v2")
+}
+
+// CgroupParent returns the directory under which the apps' cgroups are created: a
+// multi-app directory in the relay's own cgroup. It fails if the host does not use
+// cgroup v2 or the directory cannot be created.
+func CgroupParent() (string, error) {
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	parent := filepath.Join(cgroupMount, own, "multi-app")
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", err
+	}
+	// lets the apps' cgroups have memory and cpu limits, if the relay's cgroup delegates
+	// these controllers
+	err = os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+	if err != nil {
+		fmt.Println("Could not enable the memory and cpu controllers for app cgroups:", err)
+	}
+	return parent, nil
+}
+
+// NewCgroup creates, or reuses, the cgroup of the named app under parent.
+func NewCgroup(parent string, name string) (*Cgroup, error) {
+	path := filepath.Join(parent, name)
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Cgroup{Path: path}, nil
+}
+
+// SetLimits sets the memory and cpu limits of the cgroup. It fails if the controllers are
+// not enabled for the cgroup.
+func (c *Cgroup) SetLimits(resources *Resources) error {
+	if resources.MemoryMB > 0 {
+		limit := strconv.FormatInt(int64(resources.MemoryMB)*1024*1024, 10)
+		err := os.WriteFile(filepath.Join(c.Path, "memory.max"), []byte(limit), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if resources.CPUs > 0 {
+		const period = 100000
+		quota := fmt.Sprintf("%d %d", int(resources.CPUs*period), period)
+		err := os.WriteFile(filepath.Join(c.Path, "cpu.max"), []byte(quota), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Processes returns the PIDs in the cgroup.
+func (c *Cgroup) Processes() []int {
+	data, err := os.ReadFile(filepath.Join(c.Path, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Signal sends sig to every process in the cgroup.
+func (c *Cgroup) Signal(sig syscall.Signal) {
+	for _, pid := range c.Processes() {
+		syscall.Kill(pid, sig)
+	}
+}
+
+// Kill kills every process in the cgroup, through cgroup.kill where the kernel has it.
+func (c *Cgroup) Kill() {
+	err := os.WriteFile(filepath.Join(c.Path, "cgroup.kill"), []byte("1"), 0644)
+	if err != nil {
+		// cgroup.kill needs Linux 5.14
+		c.Signal(syscall.SIGKILL)
+	}
+}
+
+// wrap returns a command line that moves itself into the cgroup before running exe, so
+// everything the app starts is in the cgroup from the beginning. If the move fails, the
+// app still runs, without the cgroup.
+func (c *Cgroup) wrap(exe string, args []string) (string, []string) {
+	script := `echo $$ > "$0" 2>/dev/null || echo "could not join cgroup $0" >&2; exec "$@"`
+	wrapped := append([]string{"-c", script, filepath.Join(c.Path, "cgroup.procs"), exe}, args...)
+	return "/bin/sh", wrapped
+}
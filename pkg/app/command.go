@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+	cmd "github.com/ShinyTrinkets/overseer"
+	"os"
+)
+
+// isCommand reports whether the app is a plain command with no language-specific setup,
+// e.g. a prebuilt binary, a jar or a shell wrapper.
+func (a *App) isCommand() bool {
+	return a.Type == TypeCommand
+}
+
+func (a *App) commandCmdOptions() cmd.Options {
+	path := os.Getenv("PATH")
+	return a.cmdOptions(
+		"PATH=" + path,
+	)
+}
+
+func (a *App) startCommand() error {
+	if !a.isCommand() {
+		return nil
+	}
+	err := a.setup()
+	if err != nil {
+		fmt.Println("Error setting up app")
+		return err
+	}
+	fmt.Println("Starting app command")
+	cmdOptions := a.commandCmdOptions()
+	return a.launch(cmdOptions)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DependencyManager represents the tool that installs a Python app's dependencies.
+type DependencyManager string
+
+const (
+	DependencyManagerPip    DependencyManager = "pip"
+	DependencyManagerUv     DependencyManager = "uv"
+	DependencyManagerPoetry DependencyManager = "poetry"
+	DependencyManagerPipenv DependencyManager = "pipenv"
+)
+
+// IsValid checks if a given dependency manager is valid.
+func (d DependencyManager) IsValid() bool {
+	switch d {
+	case DependencyManagerPip, DependencyManagerUv, DependencyManagerPoetry, DependencyManagerPipenv:
+		return true
+	}
+	return false
+}
+
+// String returns the string representation of the dependency manager.
+func (d DependencyManager) String() string {
+	return string(d)
+}
+
+// PythonConfig holds the python specific settings of an app.
+type PythonConfig struct {
+	// DependencyManager overrides the one detected from the files in codePath.
+	DependencyManager DependencyManager `yaml:"dependencyManager,omitempty" json:"dependencyManager,omitempty"`
+	// Version selects the pythonX.Y interpreter on PATH, e.g. "3.10".
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	// Interpreter is the name or path of the interpreter, it takes precedence over Version.
+	Interpreter string `yaml:"interpreter,omitempty" json:"interpreter,omitempty"`
+}
+
+// InterpreterName returns the interpreter that builds the venv.
+func (p *PythonConfig) InterpreterName() string {
+	switch {
+	case p == nil:
+		return "python"
+	case p.Interpreter != "":
+		return p.Interpreter
+	case p.Version != "":
+		return "python" + p.Version
+	}
+	return "python"
+}
+
+// CheckInterpreter checks that the selected interpreter exists on this host and, when a
+// version is pinned, that it is that version.
+func (p *PythonConfig) CheckInterpreter() error {
+	if p == nil {
+		return nil
+	}
+	interpreter := p.InterpreterName()
+	if _, err := exec.LookPath(interpreter); err != nil {
+		return fmt.Errorf("python interpreter %s not found: %v", interpreter, err)
+	}
+	if p.Version == "" {
+		return nil
+	}
+	out, err := exec.Command(interpreter, "-c", "import sys; print('%d.%d.%d' % sys.version_info[:3])").Output()
+	if err != nil {
+		return fmt.Errorf("could not run python interpreter %s: %v", interpreter, err)
+	}
+	version := strings.TrimSpace(string(out))
+	if version != p.Version && !strings.HasPrefix(version, p.Version+".") {
+		return fmt.Errorf("python interpreter %s is version %s, expected %s", interpreter, version, p.Version)
+	}
+	return nil
+}
+
+// dependencyManager returns the configured dependency manager, or detects it from the
+// lockfiles in the app's root directory. It returns an empty string when the app declares
+// no dependencies.
+func (a *App) dependencyManager() DependencyManager {
+	if a.Python != nil && a.Python.DependencyManager != "" {
+		return a.Python.DependencyManager
+	}
+	switch {
+	case a.hasFile("uv.lock") && a.hasFile("pyproject.toml"):
+		return DependencyManagerUv
+	case a.hasFile("poetry.lock"):
+		return DependencyManagerPoetry
+	case a.hasFile("Pipfile.lock"):
+		return DependencyManagerPipenv
+	case a.hasFile("requirements.txt"), a.hasFile("pyproject.toml"):
+		return DependencyManagerPip
+	}
+	return ""
+}
+
+// installCommand returns the shell command that installs the app's dependencies into
+// the activated venv, together with the extra environment it needs.
+func (a *App) installCommand(manager DependencyManager) (string, []string) {
+	venvDir := a.pythonVenvPath().VenvDir
+	switch manager {
+	case DependencyManagerUv:
+		return "uv sync --frozen", []string{"UV_PROJECT_ENVIRONMENT=" + venvDir}
+	case DependencyManagerPoetry:
+		return "poetry install --no-root --no-interaction", []string{"POETRY_VIRTUALENVS_CREATE=false"}
+	case DependencyManagerPipenv:
+		return "pipenv sync", []string{"PIPENV_VENV_IN_PROJECT=1", "VIRTUAL_ENV=" + venvDir}
+	}
+	if a.hasFile("requirements.txt") {
+		return fmt.Sprintf("pip install -r %s/requirements.txt", a.RootDir), nil
+	}
+	return "pip install .", nil
+}
+
+func (a *App) installDependencies() error {
+	if !a.isPython() {
+		return nil
+	}
+	manager := a.dependencyManager()
+	if manager == "" {
+		fmt.Println("No python dependencies declared, skipping install")
+		return nil
+	}
+	fmt.Println("Installing dependencies with", manager)
+	cmdOptions := a.pythonCmdOptions()
+	commandStr, env := a.installCommand(manager)
+	cmdOptions.Env = append(cmdOptions.Env, env...)
+	sourcedCmd := fmt.Sprintf("source %s && %s", a.pythonVenvPath().ActivatePath, commandStr)
+	return a.runSetupStep("installDependencies", "/bin/bash", []string{"-c", sourcedCmd},
+		cmdOptions)
+}
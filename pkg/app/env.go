@@ -0,0 +1,147 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// redactedValue replaces secret values in anything that leaves the relay.
+const redactedValue = "******"
+
+// defaultParentEnv are the variables of the relay every app inherits. Anything else,
+// e.g. DATABRICKS_* tokens, has to be passed through explicitly with envFrom.
+var defaultParentEnv = []string{"HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR", "SHELL"}
+
+// sensitiveNameParts mark variables that are redacted even if they are not flagged secret.
+var sensitiveNameParts = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "API_KEY", "PRIVATE_KEY"}
+
+// EnvVar is a single environment variable set for an app.
+type EnvVar struct {
+	Name   string `yaml:"name" json:"name"`
+	Value  string `yaml:"value" json:"value"`
+	Secret bool   `yaml:"secret,omitempty" json:"secret,omitempty"`
+}
+
+// EnvSource pulls a set of variables into an app's environment, either from a dotenv
+// style file or from the relay's own environment.
+type EnvSource struct {
+	// File is a file of KEY=VALUE lines, relative to the working directory of the relay.
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Parent lists variables of the relay to pass through. A trailing * matches a prefix,
+	// e.g. DATABRICKS_*.
+	Parent []string `yaml:"parent,omitempty" json:"parent,omitempty"`
+}
+
+// IsSensitive reports whether the variable's value must not be shown.
+func (e EnvVar) IsSensitive() bool {
+	if e.Secret {
+		return true
+	}
+	name := strings.ToUpper(e.Name)
+	for _, part := range sensitiveNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the variable with its value hidden if it is sensitive.
+func (e EnvVar) Redacted() EnvVar {
+	if e.IsSensitive() {
+		e.Value = redactedValue
+	}
+	return e
+}
+
+// matchesName reports whether name is in the allowlist, honouring trailing * prefixes.
+func matchesName(allowlist []string, name string) bool {
+	for _, allowed := range allowlist {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parentEnv returns the variables of the relay whose names are in the allowlist.
+func parentEnv(allowlist []string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if matchesName(allowlist, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// readEnvFile parses a dotenv style file: KEY=VALUE lines, blank lines and # comments,
+// an optional "export " prefix and optionally quoted values.
+func readEnvFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, found := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", filename, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// resolveEnv builds the configured environment of the app: the default parent variables,
+// then envFrom sources in order, then env. Later entries win.
+func (a *App) resolveEnv() error {
+	env := parentEnv(defaultParentEnv)
+	for _, source := range a.EnvFrom {
+		if source.File != "" {
+			fileEnv, err := readEnvFile(source.File)
+			if err != nil {
+				return fmt.Errorf("could not read env file: %v", err)
+			}
+			env = append(env, fileEnv...)
+		}
+		env = append(env, parentEnv(source.Parent)...)
+	}
+	for _, envVar := range a.Env {
+		env = append(env, envVar.Name+"="+envVar.Value)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.resolvedEnv = env
+	return nil
+}
+
+// environment returns a copy of the environment resolved for the current run.
+func (a *App) environment() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string{}, a.resolvedEnv...)
+}
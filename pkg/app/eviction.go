@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// lastUsed returns when the app last had a request, counting from the end of long ones
+// such as websockets, or when it was started if that was later. busy is set while a
+// request is being relayed to it, or while room is being made for it to start.
+func (a *App) lastUsed() (used time.Time, busy bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	busy = a.inFlight > 0 || a.launching
+	if a.lastActivity.After(a.StartedAt) {
+		return a.lastActivity, busy
+	}
+	return a.StartedAt, busy
+}
+
+// evictionCandidate returns the active app to stop to make room for app: among the
+// unpinned apps whose priority is not above app's and that serve no request right now,
+// the least recently used one of the lowest priority. Streamlit and Gradio keep a whole
+// session on one websocket, so an app with a request in flight is in use. Apps in
+// excluded are already being evicted. It returns nil if no app may be evicted. The
+// caller holds am.Mutex.
+func (am *RunManager) evictionCandidate(app *App, excluded map[*App]bool) *App {
+	var candidate *App
+	var candidateUsed time.Time
+	for _, active := range am.ActiveApps {
+		if active == app || excluded[active] || active.Pinned || active.Priority > app.Priority {
+			continue
+		}
+		used, busy := active.lastUsed()
+		if busy {
+			continue
+		}
+		if candidate == nil || active.Priority < candidate.Priority ||
+			(active.Priority == candidate.Priority && used.Before(candidateUsed)) {
+			candidate = active
+			candidateUsed = used
+		}
+	}
+	return candidate
+}
+
+// checkCapacity reports why app cannot be started next to the active apps, not counting
+// those in excluded: the app limit or the resources of the host. The caller holds
+// am.Mutex.
+func (am *RunManager) checkCapacity(app *App, host HostResources, excluded map[*App]bool) error {
+	if am.Limit > 0 && len(am.ActiveApps)-len(excluded) >= am.Limit {
+		return fmt.Errorf("app limit reached")
+	}
+	running := make([]*App, 0, len(am.ActiveApps))
+	for _, active := range am.ActiveApps {
+		running = append(running, active)
+	}
+	return admit(host, app, running, excluded)
+}
+
+// makeRoom returns the apps to evict so app can start, least recently used first. If
+// evicting every app that may be evicted is not enough, it returns why app cannot start.
+// The caller holds am.Mutex.
+func (am *RunManager) makeRoom(app *App) ([]*App, error) {
+	host := ReadHostResources()
+	excluded := make(map[*App]bool)
+	var evicted []*App
+	for {
+		err := am.checkCapacity(app, host, excluded)
+		if err == nil {
+			break
+		}
+		candidate := am.evictionCandidate(app, excluded)
+		if candidate == nil {
+			return nil, err
+		}
+		excluded[candidate] = true
+		evicted = append(evicted, candidate)
+	}
+	// an app evicted early may not have been needed once a bigger one was picked
+	kept := evicted[:0]
+	for i := len(evicted) - 1; i >= 0; i-- {
+		delete(excluded, evicted[i])
+		if am.checkCapacity(app, host, excluded) == nil {
+			continue
+		}
+		excluded[evicted[i]] = true
+	}
+	for _, candidate := range evicted {
+		if excluded[candidate] {
+			kept = append(kept, candidate)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// activeApp describes an app already running when makeRoom is asked for room.
+type activeApp struct {
+	name     string
+	priority int
+	pinned   bool
+	started  time.Duration // How long ago the app was started
+	used     time.Duration // How long ago a request was last relayed to it, zero if never
+	ended    time.Duration // How long ago that request ended, zero if it ended right away
+	inFlight int           // Requests being relayed to it right now
+}
+
+func TestMakeRoom(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		active   []activeApp
+		priority int // Priority of the app to start
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:   "below the limit",
+			limit:  3,
+			active: []activeApp{{name: "a", started: time.Hour}, {name: "b", started: time.Hour}},
+		},
+		{
+			name:  "least recently used",
+			limit: 3,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: 3 * time.Minute},
+				{name: "b", started: time.Hour, used: time.Minute},
+				{name: "c", started: time.Hour, used: 2 * time.Minute},
+			},
+			want: []string{"a"},
+		},
+		{
+			name:  "never used counts from the start",
+			limit: 2,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: 5 * time.Minute},
+				{name: "b", started: 10 * time.Minute},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:  "apps serving a request stay",
+			limit: 2,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: 30 * time.Minute, inFlight: 1},
+				{name: "b", started: time.Hour, used: time.Minute},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:  "long requests count from their end",
+			limit: 2,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: 30 * time.Minute, ended: time.Second},
+				{name: "b", started: time.Hour, used: time.Minute},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:  "every app is serving a request",
+			limit: 1,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: time.Minute, inFlight: 2},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "pinned apps stay",
+			limit: 2,
+			active: []activeApp{
+				{name: "a", pinned: true, started: time.Hour},
+				{name: "b", started: time.Hour, used: time.Minute},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:     "lowest priority first",
+			limit:    2,
+			priority: 5,
+			active: []activeApp{
+				{name: "a", priority: 2, started: time.Hour},
+				{name: "b", priority: 1, started: time.Hour, used: time.Second},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:     "higher priority apps stay",
+			limit:    1,
+			priority: 1,
+			active:   []activeApp{{name: "a", priority: 2, started: time.Hour}},
+			wantErr:  true,
+		},
+		{
+			name:  "nothing may be evicted",
+			limit: 2,
+			active: []activeApp{
+				{name: "a", pinned: true, started: time.Hour},
+				{name: "b", pinned: true, started: time.Hour},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "over the limit after a reload",
+			limit: 1,
+			active: []activeApp{
+				{name: "a", started: time.Hour, used: time.Minute},
+				{name: "b", started: time.Hour, used: 2 * time.Minute},
+			},
+			want: []string{"b", "a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Now()
+			manager := NewAppRunManager(test.limit)
+			for _, active := range test.active {
+				app := appWith(active.name, 0, 0)
+				app.Priority = active.priority
+				app.Pinned = active.pinned
+				app.StartedAt = now.Add(-active.started)
+				if active.used != 0 {
+					app.LastRequestAt = now.Add(-active.used)
+					app.lastActivity = app.LastRequestAt
+				}
+				if active.ended != 0 {
+					app.lastActivity = now.Add(-active.ended)
+				}
+				app.inFlight = active.inFlight
+				manager.ActiveApps[app.ID] = app
+			}
+			app := appWith("new", 0, 0)
+			app.Priority = test.priority
+
+			evicted, err := manager.makeRoom(app)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("makeRoom evicts %v, want an error", evicted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, 0, len(evicted))
+			for _, app := range evicted {
+				names = append(names, app.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(test.want) {
+				t.Errorf("makeRoom evicts %v, want %v", names, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HealthCheckType represents how an app's readiness is probed.
+type HealthCheckType string
+
+const (
+	HealthCheckHTTP HealthCheckType = "http"
+	HealthCheckTCP  HealthCheckType = "tcp"
+)
+
+// IsValid checks if a given health check type is valid.
+func (t HealthCheckType) IsValid() bool {
+	switch t {
+	case HealthCheckHTTP, HealthCheckTCP:
+		return true
+	}
+	return false
+}
+
+// String returns the string representation of the health check type.
+func (t HealthCheckType) String() string {
+	return string(t)
+}
+
+// AppHost is the host the relay reaches the apps on, both to proxy to them and to probe
+// them. localhost resolves to 127.0.0.1 and ::1, so apps may listen on either.
+const AppHost = "localhost"
+
+const (
+	defaultHealthCheckInterval  = 1 * time.Second
+	defaultHealthCheckTimeout   = 1 * time.Second
+	defaultHealthCheckThreshold = 3
+)
+
+// HealthCheck configures the probe that decides when an app can receive traffic.
+// Apps without one get a TCP connect check against their port.
+type HealthCheck struct {
+	Type             HealthCheckType `yaml:"type" json:"type"`
+	Path             string          `yaml:"path,omitempty" json:"path,omitempty"`
+	ExpectedStatus   int             `yaml:"expectedStatus,omitempty" json:"expectedStatus,omitempty"`
+	Interval         Duration        `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout          Duration        `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	FailureThreshold int             `yaml:"failureThreshold,omitempty" json:"failureThreshold,omitempty"`
+}
+
+func defaultHealthCheck() *HealthCheck {
+	return &HealthCheck{Type: HealthCheckTCP}
+}
+
+func (h *HealthCheck) failureThreshold() int {
+	if h.FailureThreshold <= 0 {
+		return defaultHealthCheckThreshold
+	}
+	return h.FailureThreshold
+}
+
+// Check probes the app listening on the given port once.
+func (h *HealthCheck) Check(port int) error {
+	timeout := h.Timeout.OrDefault(defaultHealthCheckTimeout)
+	address := net.JoinHostPort(AppHost, fmt.Sprintf("%d", port))
+	if h.Type != HealthCheckHTTP {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		// the status of the probed path is what counts, not wherever it redirects to
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("http://%s%s", address, h.Path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if h.ExpectedStatus != 0 && resp.StatusCode != h.ExpectedStatus {
+		return fmt.Errorf("expected status %d, got %d", h.ExpectedStatus, resp.StatusCode)
+	}
+	if h.ExpectedStatus == 0 && resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unhealthy status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// monitorHealth probes the app until done is closed. The app becomes ready on the first
+// passing check and unhealthy after FailureThreshold consecutive failures.
+func (a *App) monitorHealth(done <-chan struct{}) {
+	check := a.HealthCheck
+	if check == nil {
+		check = defaultHealthCheck()
+	}
+	ticker := time.NewTicker(check.Interval.OrDefault(defaultHealthCheckInterval))
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := check.Check(a.PreferredPort)
+		if err == nil {
+			failures = 0
+			if a.transitionStatus(StatusReady, StatusRunning, StatusUnhealthy) {
+				fmt.Println("App", a.Name, "is ready")
+				a.mutex.Lock()
+				a.readyAt = time.Now()
+				a.mutex.Unlock()
+			}
+			continue
+		}
+		failures++
+		if failures >= check.failureThreshold() && a.transitionStatus(StatusUnhealthy, StatusReady) {
+			fmt.Println("App", a.Name, "is unhealthy:", err)
+			a.mutex.Lock()
+			a.readyAt = time.Time{}
+			a.mutex.Unlock()
+		}
+	}
+}
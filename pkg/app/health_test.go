@@ -0,0 +1,46 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+// TestHealthCheckReachesAppHost checks that an app listening on any of the addresses the
+// proxy may reach it on, e.g. only on ::1, passes its health check.
+func TestHealthCheckReachesAppHost(t *testing.T) {
+	addresses, err := net.LookupHost(AppHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+		if err != nil {
+			t.Logf("skipping %s: %v", address, err)
+			continue
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" {
+				http.NotFound(w, r)
+			}
+		})}
+		go server.Serve(listener)
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		checks := []struct {
+			check   *HealthCheck
+			wantErr bool
+		}{
+			{&HealthCheck{Type: HealthCheckTCP}, false},
+			{&HealthCheck{Type: HealthCheckHTTP, Path: "/health"}, false},
+			{&HealthCheck{Type: HealthCheckHTTP, Path: "/missing"}, true},
+		}
+		for _, c := range checks {
+			err := c.check.Check(port)
+			if (err != nil) != c.wantErr {
+				t.Errorf("%s %s check on %s: %v, want error %v", c.check.Type, c.check.Path, listener.Addr(), err, c.wantErr)
+			}
+		}
+		server.Close()
+	}
+}
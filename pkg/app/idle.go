@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// idleCheckInterval is how often the idle reaper looks for apps to stop.
+const idleCheckInterval = 15 * time.Second
+
+// BeginRequest records that a request to the app is being relayed. Until the matching
+// EndRequest, the app is not idle, which keeps websockets and streams alive.
+func (a *App) BeginRequest() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.inFlight++
+	a.LastRequestAt = time.Now()
+	a.lastActivity = a.LastRequestAt
+}
+
+// EndRequest records that a request started with BeginRequest has finished.
+func (a *App) EndRequest() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.inFlight--
+	a.lastActivity = time.Now()
+}
+
+// idleFor returns how long the app has gone without requests, since it was launched.
+// It is zero while a request is in flight, or if the app is not up.
+func (a *App) idleFor(now time.Time) time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.inFlight > 0 || (a.Status != StatusReady && a.Status != StatusUnhealthy) {
+		return 0
+	}
+	return now.Sub(a.lastActivity)
+}
+
+// ReapIdleApps stops apps that have been idle for longer than their idle timeout,
+// until stop is closed. This frees their slot for other apps.
+func (am *RunManager) ReapIdleApps(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				am.reapIdle(now)
+				// memory freed outside the relay can make room for queued apps too
+				am.startQueued()
+			}
+		}
+	}()
+}
+
+func (am *RunManager) reapIdle(now time.Time) {
+	for _, app := range am.ListRunningApps() {
+		if app.IdleTimeout <= 0 {
+			continue
+		}
+		idle := app.idleFor(now)
+		if idle < app.IdleTimeout {
+			continue
+		}
+		fmt.Println("App", app.Name, "was idle for", idle.Round(time.Second), "stopping it")
+		err := am.StopApp(app)
+		if err != nil {
+			fmt.Println("Error stopping idle app", app.Name, err)
+		}
+	}
+}
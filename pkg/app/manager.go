@@ -1,21 +1,50 @@
 package app
 
 import (
+	"errors"
 	"fmt"
-	"gopkg.in/yaml.v3"
 	"multi-app-relay-service/pkg/ui"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 )
 
-const ManagementPort = 7999
+const (
+	DefaultBasePort       = 8001
+	DefaultMaxPort        = 8999
+	DefaultManagementPort = 7999
+	DefaultAppLimit       = 5
+)
+
+// ManagerOptions are the settings of a Manager that do not come from the config file.
+type ManagerOptions struct {
+	BasePort       int    // First port handed out to apps
+	MaxPort        int    // Last port handed out to apps
+	ManagementPort int    // Port of the management ui
+	AppLimit       int    // Maximum number of apps running at once, zero for no limit besides resources
+	RelayURL       string // URL the management ui reaches the relay's API on
+	Cgroups        bool   // Run every app in its own cgroup, where the host supports it
+}
+
+// DefaultManagerOptions returns the options the relay runs with when none are given.
+func DefaultManagerOptions() ManagerOptions {
+	return ManagerOptions{
+		BasePort:       DefaultBasePort,
+		MaxPort:        DefaultMaxPort,
+		ManagementPort: DefaultManagementPort,
+		AppLimit:       DefaultAppLimit,
+		RelayURL:       "http://localhost:8000",
+	}
+}
 
 type RunManager struct {
 	ActiveApps map[string]*App // Map of running apps by ID
-	Limit      int             // Maximum number of apps
+	Limit      int             // Maximum number of apps, zero for no limit besides resources
 	Mutex      sync.Mutex      // Mutex for concurrency control
+
+	queue        []*queuedStart // Start requests waiting for room, in the order they will be served
+	shuttingDown bool           // Set once the relay shuts down, no app is started or restarted after
 }
 
 func NewAppRunManager(limit int) *RunManager {
@@ -25,30 +54,241 @@ func NewAppRunManager(limit int) *RunManager {
 	}
 }
 
+// RunApp starts the app if the app limit and the resources of the host allow. If they do
+// not, the least recently used apps that may be evicted are stopped first to make room,
+// see evictionCandidate. If that is not enough either, the app waits in the start queue
+// and a *QueuedError is returned.
 func (am *RunManager) RunApp(app *App) error {
+	am.Mutex.Lock()
+	if am.shuttingDown {
+		am.Mutex.Unlock()
+		return fmt.Errorf("relay is shutting down")
+	}
+	if _, active := am.ActiveApps[app.ID]; active {
+		app.mutex.Lock()
+		launching, stopped := app.launching, app.Status.IsStopped()
+		app.mutex.Unlock()
+		switch {
+		case launching:
+			// a concurrent call is stopping the evicted apps to start it
+			am.Mutex.Unlock()
+			return nil
+		case !stopped:
+			am.Mutex.Unlock()
+			return errors.New("app is already running or starting to run")
+		}
+		// the app is waiting out its restart backoff, start it right away
+		am.activate(app, nil)
+		am.Mutex.Unlock()
+		return am.launch(app, nil)
+	}
+	if am.queuePosition(app) == 0 {
+		if !app.GetStatus().IsStopped() {
+			am.Mutex.Unlock()
+			return errors.New("app is already running or starting to run")
+		}
+		err := fitsHost(ReadHostResources(), app)
+		if err != nil {
+			am.Mutex.Unlock()
+			return err
+		}
+		am.enqueue(app, errors.New("waiting for room"))
+	}
+	am.Mutex.Unlock()
+
+	started := am.startQueued()
+	if err, ok := started[app]; ok {
+		return err
+	}
 	am.Mutex.Lock()
 	defer am.Mutex.Unlock()
+	position := am.queuePosition(app)
+	if position == 0 {
+		// a concurrent call started it, or it was cancelled
+		return nil
+	}
+	err := &QueuedError{Position: position, Reason: am.queue[position-1].reason}
+	fmt.Println("App", app.Name, "is queued:", err)
+	return err
+}
 
-	if len(am.ActiveApps) >= am.Limit {
-		return fmt.Errorf("app limit reached")
+// activate makes the app active in place of the evicted apps, and marks it as launching
+// until launch starts it. The caller holds am.Mutex.
+func (am *RunManager) activate(app *App, evicted []*App) {
+	for _, evictedApp := range evicted {
+		fmt.Println("Stopping least recently used app", evictedApp.Name, "to make room for", app.Name)
+		delete(am.ActiveApps, evictedApp.ID)
 	}
 	am.ActiveApps[app.ID] = app
+	app.mutex.Lock()
+	app.RestartCount = 0
+	app.onExit = am.handleExit
+	app.launching = true
+	app.mutex.Unlock()
+}
+
+// launch stops the evicted apps and starts the app, once activate made room for it. The
+// app is not started if it was stopped in the meantime, and gives up its slot if it
+// fails to start.
+func (am *RunManager) launch(app *App, evicted []*App) error {
+	// the evicted apps get to shut down cleanly before the new one takes their memory
+	for _, evictedApp := range evicted {
+		evictedApp.Stop()
+	}
+	am.Mutex.Lock()
+	defer am.Mutex.Unlock()
+	app.mutex.Lock()
+	app.launching = false
+	app.mutex.Unlock()
+	if am.ActiveApps[app.ID] != app {
+		return errors.New("app was stopped before it was started")
+	}
 	err := app.Start()
+	if err != nil {
+		// the app gives the room made for it to the apps in the queue
+		delete(am.ActiveApps, app.ID)
+		go am.startQueued()
+	}
 	return err
 }
 
-func (am *RunManager) StopApp(app *App) error {
+// handleExit applies the app's restart policy once its process exits on its own.
+// Apps that are not restarted give up their slot.
+func (am *RunManager) handleExit(app *App) {
+	if app.StopRequested() {
+		return
+	}
+	app.mutex.Lock()
+	if ranStable(app.readyAt, app.StoppedAt) {
+		app.RestartCount = 0
+	}
+	restart := app.RestartPolicy.shouldRestart(app.Status == StatusFailed, app.RestartCount)
+	restarts := app.RestartCount
+	app.mutex.Unlock()
+
 	am.Mutex.Lock()
-	defer am.Mutex.Unlock()
+	shuttingDown := am.shuttingDown
+	am.Mutex.Unlock()
+	if !restart || shuttingDown {
+		am.Mutex.Lock()
+		delete(am.ActiveApps, app.ID)
+		am.Mutex.Unlock()
+		go am.startQueued()
+		return
+	}
+
+	delay := app.RestartPolicy.delay(restarts)
+	fmt.Println("App", app.Name, "stopped with status", app.GetStatus(), "restarting in", delay)
+	time.AfterFunc(delay, func() {
+		am.Mutex.Lock()
+		defer am.Mutex.Unlock()
+		// the app was stopped or started by hand while we were backing off
+		if _, active := am.ActiveApps[app.ID]; !active || am.shuttingDown || app.StopRequested() || !app.GetStatus().IsStopped() {
+			return
+		}
+		app.mutex.Lock()
+		app.RestartCount++
+		app.mutex.Unlock()
+		err := app.Start()
+		if err != nil {
+			fmt.Println("Error restarting app", app.Name, err)
+			// like an app that is not restarted, it gives up its slot
+			delete(am.ActiveApps, app.ID)
+			go am.startQueued()
+		}
+	})
+}
 
+// StopApp stops the app and waits for it to exit. The lock is not held while waiting, so
+// several apps can be stopped at once.
+func (am *RunManager) StopApp(app *App) error {
+	am.Mutex.Lock()
 	if _, exists := am.ActiveApps[app.ID]; !exists {
+		cancelled := am.dequeue(app)
+		am.Mutex.Unlock()
+		if cancelled {
+			fmt.Println("Cancelled queued start of app", app.Name)
+			return nil
+		}
 		return fmt.Errorf("app not found")
 	}
-	app.Stop()
 	delete(am.ActiveApps, app.ID)
+	am.Mutex.Unlock()
+	app.Stop()
+	go am.startQueued()
 	return nil
 }
 
+// BeginShutdown refuses to start any more apps, and marks the active apps as stopped
+// on purpose, so an app exiting while requests drain is neither restarted nor reported
+// as failed. The apps keep running until Shutdown.
+func (am *RunManager) BeginShutdown() {
+	am.Mutex.Lock()
+	defer am.Mutex.Unlock()
+	am.shuttingDown = true
+	for len(am.queue) > 0 {
+		am.dequeue(am.queue[0].app)
+	}
+	for _, app := range am.ActiveApps {
+		app.mutex.Lock()
+		app.stopRequested = true
+		app.mutex.Unlock()
+	}
+}
+
+// Shutdown stops every active app and refuses to start any more. The apps are stopped
+// concurrently, except for the given last app, which is stopped after all the others.
+// It gives up waiting after the timeout.
+func (am *RunManager) Shutdown(last *App, timeout time.Duration) error {
+	am.BeginShutdown()
+
+	deadline := time.Now().Add(timeout)
+	var wg sync.WaitGroup
+	for _, app := range am.ListRunningApps() {
+		if app == last {
+			continue
+		}
+		wg.Add(1)
+		go func(app *App) {
+			defer wg.Done()
+			fmt.Println("Stopping app", app.Name)
+			err := am.StopApp(app)
+			if err != nil {
+				// it exited on its own in the meantime
+				fmt.Println("Error stopping app", app.Name, err)
+			}
+		}(app)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("timed out stopping apps")
+	}
+
+	if last == nil {
+		return nil
+	}
+	if _, err := am.GetRunningApp(last.ID); err != nil {
+		return nil
+	}
+	lastStopped := make(chan error, 1)
+	go func() {
+		fmt.Println("Stopping app", last.Name)
+		lastStopped <- am.StopApp(last)
+	}()
+	select {
+	case err := <-lastStopped:
+		return err
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("timed out stopping app %s", last.Name)
+	}
+}
+
 func (am *RunManager) GetRunningApp(id string) (*App, error) {
 	am.Mutex.Lock()
 	defer am.Mutex.Unlock()
@@ -77,19 +317,71 @@ type Manager struct {
 	AppPorts      map[string]int
 	AppsConfig    *AppsConfig
 	ManagementApp *App
+	ConfigFile    string // YAML file the config was loaded from, re-read on reload
+	Options       ManagerOptions
+	PortAllocator *PortAllocator
+
+	cgroupParent string       // Directory the apps' cgroups are created in, empty without cgroups
+	mutex        sync.RWMutex // Guards the apps, ports and config, which change on reload
+	reloadMutex  sync.Mutex   // Serializes reloads, which stop and start apps outside mutex
 }
 
-func NewManager() *Manager {
+func NewManager(options ManagerOptions) *Manager {
+	cgroupParent := ""
+	if options.Cgroups {
+		parent, err := CgroupParent()
+		if err != nil {
+			fmt.Println("Cgroups are not available, apps run without them:", err)
+		}
+		cgroupParent = parent
+	}
 	return &Manager{
 		AllApps:       make([]*App, 0),
 		AppPorts:      make(map[string]int),
-		RunManager:    NewAppRunManager(5),
+		RunManager:    NewAppRunManager(options.AppLimit),
 		AppsConfig:    nil,
 		ManagementApp: nil,
+		Options:       options,
+		PortAllocator: NewPortAllocator(options.BasePort, options.MaxPort),
+		cgroupParent:  cgroupParent,
+	}
+}
+
+// Apps returns the apps of the current config.
+func (m *Manager) Apps() []*App {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]*App{}, m.AllApps...)
+}
+
+// Config returns the current config.
+func (m *Manager) Config() *AppsConfig {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.AppsConfig
+}
+
+// Ports returns the ports of all apps by app ID.
+func (m *Manager) Ports() map[string]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	ports := make(map[string]int, len(m.AppPorts))
+	for id, port := range m.AppPorts {
+		ports[id] = port
 	}
+	return ports
+}
+
+// GetManagementApp returns the management ui app.
+func (m *Manager) GetManagementApp() *App {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.ManagementApp
 }
 
 func (m *Manager) GetApp(id string) (*App, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	for _, app := range m.AllApps {
 		if app.ID == id {
 			return app, nil
@@ -99,6 +391,8 @@ func (m *Manager) GetApp(id string) (*App, error) {
 }
 
 func (m *Manager) GetAppConfig(id string) (*Config, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	for _, app := range m.AppsConfig.Apps {
 		if app.Name == id {
 			return app, nil
@@ -108,6 +402,8 @@ func (m *Manager) GetAppConfig(id string) (*Config, error) {
 }
 
 func (m *Manager) GetAppPort(id string) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	port, exists := m.AppPorts[id]
 	if !exists {
 		return 0, fmt.Errorf("app not found")
@@ -115,6 +411,32 @@ func (m *Manager) GetAppPort(id string) (int, error) {
 	return port, nil
 }
 
+// newApp builds the app of a config, on the given port.
+func (m *Manager) newApp(c *Config, port int) (*App, error) {
+	app, err := c.ToApp(port)
+	if err != nil {
+		return nil, err
+	}
+	app.CgroupParent = m.cgroupParent
+	return app, nil
+}
+
+// newManagementApp builds the management ui app, which is told where to find the relay.
+func (m *Manager) newManagementApp(c *Config) (*App, error) {
+	managementApp, err := m.newApp(c, m.Options.ManagementPort)
+	if err != nil {
+		return nil, err
+	}
+	managementApp.Env = append(managementApp.Env, EnvVar{Name: "MULTI_APP_RELAY_URL", Value: m.Options.RelayURL})
+	managementApp.Pinned = true
+	return managementApp, nil
+}
+
+// Shutdown stops all apps, the management ui last, within the timeout.
+func (m *Manager) Shutdown(timeout time.Duration) error {
+	return m.RunManager.Shutdown(m.GetManagementApp(), timeout)
+}
+
 func (m *Manager) StageUICode() error {
 	return ui.CopyEmbeddedFiles(m.ManagementApp.RootDir)
 }
@@ -140,38 +462,108 @@ type AppsConfig struct {
 	Repos        []*GitRepo `yaml:"repos,omitempty" json:"repos,omitempty"`
 }
 
+// Redacted returns a copy of the config that is safe to hand out, with the values of
+// secret environment variables hidden.
+func (ac *AppsConfig) Redacted() *AppsConfig {
+	redacted := *ac
+	redacted.Apps = make([]*Config, 0, len(ac.Apps))
+	for _, appConfig := range ac.Apps {
+		redacted.Apps = append(redacted.Apps, appConfig.Redacted())
+	}
+	if ac.ManagementUi != nil {
+		redacted.ManagementUi = ac.ManagementUi.Redacted()
+	}
+	return &redacted
+}
+
 type Config struct {
-	Name              string  `yaml:"name" json:"name"`
-	Command           string  `yaml:"command" json:"command"`
-	RoutePath         *string `yaml:"routePath,omitempty" json:"routePath"`
-	CodePath          *string `yaml:"codePath,omitempty" json:"codePath"`
-	PassFullProxyPath bool    `yaml:"passFullProxyPath,omitempty" json:"passFullProxyPath,omitempty"`
-	Type              Type    `yaml:"type" json:"type"`
-	Meta              *Meta   `yaml:"meta" json:"meta"`
+	Name              string         `yaml:"name" json:"name"`
+	Command           CommandSpec    `yaml:"command" json:"command"`
+	Args              []string       `yaml:"args,omitempty" json:"args,omitempty"`
+	RoutePath         *string        `yaml:"routePath,omitempty" json:"routePath"`
+	Port              int            `yaml:"port,omitempty" json:"port,omitempty"`
+	CodePath          *string        `yaml:"codePath,omitempty" json:"codePath"`
+	PassFullProxyPath bool           `yaml:"passFullProxyPath,omitempty" json:"passFullProxyPath,omitempty"`
+	Type              Type           `yaml:"type" json:"type"`
+	HealthCheck       *HealthCheck   `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+	IdleTimeout       Duration       `yaml:"idleTimeout,omitempty" json:"idleTimeout,omitempty"`
+	AutoStart         bool           `yaml:"autoStart,omitempty" json:"autoStart,omitempty"`
+	Priority          int            `yaml:"priority,omitempty" json:"priority,omitempty"`
+	Pinned            bool           `yaml:"pinned,omitempty" json:"pinned,omitempty"`
+	Resources         *Resources     `yaml:"resources,omitempty" json:"resources,omitempty"`
+	RestartPolicy     *RestartPolicy `yaml:"restartPolicy,omitempty" json:"restartPolicy,omitempty"`
+	Python            *PythonConfig  `yaml:"python,omitempty" json:"python,omitempty"`
+	Env               []EnvVar       `yaml:"env,omitempty" json:"env,omitempty"`
+	EnvFrom           []EnvSource    `yaml:"envFrom,omitempty" json:"envFrom,omitempty"`
+	Meta              *Meta          `yaml:"meta" json:"meta"`
 }
 
 func (c *Config) ToApp(port int) (*App, error) {
+	rootDir, err := c.rootDir()
+	if err != nil {
+		return nil, err
+	}
+	app := NewApp(c.Name, rootDir, c.Name, c.Type, nil, port)
+	err = c.configure(app, port)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// rootDir returns the absolute directory of the app's code.
+func (c *Config) rootDir() (string, error) {
 	if c.RoutePath == nil {
-		return nil, fmt.Errorf("routePath not found in config")
+		return "", fmt.Errorf("routePath not found in config")
 	}
 	if c.CodePath == nil {
-		return nil, fmt.Errorf("codePath not found in config")
+		return "", fmt.Errorf("codePath not found in config")
 	}
 	wd, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	rootDir := *c.CodePath
 	if !filepath.IsAbs(rootDir) {
 		rootDir = filepath.Join(wd, rootDir)
 	}
-	c.Command = strings.Replace(c.Command, "${PORT}", fmt.Sprintf("%d", port), -1)
-	commands, err := c.ToCommandArray()
+	return rootDir, nil
+}
+
+// configure sets up the app to run the config on the given port. The app must not be
+// running.
+func (c *Config) configure(app *App, port int) error {
+	rendered := NewTemplateContext(c, app.RootDir, port).Render(c)
+	commands, err := rendered.ToCommandArray()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return NewApp(c.Name, rootDir, c.Name, c.Type, commands, port), nil
+	app.Command = commands
+	app.PreferredPort = port
+	app.ShellCommand = rendered.ShellCommand()
+	app.HealthCheck = rendered.HealthCheck
+	app.RestartPolicy = c.RestartPolicy
+	app.IdleTimeout = time.Duration(c.IdleTimeout)
+	app.AutoStart = c.AutoStart
+	app.Priority = c.Priority
+	app.Pinned = c.Pinned
+	app.Resources = c.Resources
+	app.Python = c.Python
+	app.Env = rendered.Env
+	app.EnvFrom = rendered.EnvFrom
+	return nil
+}
+
+// Redacted returns a copy of the config with the values of secret environment
+// variables hidden.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Env = make([]EnvVar, 0, len(c.Env))
+	for _, envVar := range c.Env {
+		redacted.Env = append(redacted.Env, envVar.Redacted())
+	}
+	return &redacted
 }
 
 type Meta struct {
@@ -181,54 +573,166 @@ type Meta struct {
 	Tags        []string `yaml:"tags,omitempty" json:"tags,omitempty"`
 }
 
+// ToCommandArray returns the command and args as an argument list. Shell commands are
+// split following POSIX quoting rules.
 func (c *Config) ToCommandArray() ([]string, error) {
-	var commands []string
-	for _, cmdStr := range strings.Split(c.Command, " ") {
-		if cmdStr != "" {
-			commands = append(commands, cmdStr)
+	commands := c.Command.Exec
+	if !c.Command.IsExec() {
+		words, err := splitShellWords(c.Command.Shell)
+		if err != nil {
+			return nil, err
 		}
+		commands = words
 	}
+	commands = append(append([]string{}, commands...), c.Args...)
 	if len(commands) == 0 {
 		return nil, fmt.Errorf("no commands found")
 	}
 	return commands, nil
 }
 
-func NewManagerFromYaml(filename string) (*Manager, error) {
-	manager := NewManager()
+// ShellCommand returns the shell string to run for shell form commands, with args quoted
+// onto the end. It is empty for exec form commands.
+func (c *Config) ShellCommand() string {
+	if c.Command.IsExec() {
+		return ""
+	}
+	shellCommand := c.Command.Shell
+	for _, arg := range c.Args {
+		shellCommand += " " + quoteShellWord(arg)
+	}
+	return shellCommand
+}
 
+// LoadConfig reads and validates the YAML config, and checks that the python
+// interpreters it asks for exist on this host.
+func LoadConfig(filename string) (*AppsConfig, error) {
 	yamlFile, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	config, err := ParseConfig(yamlFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", filename, err)
+	}
+	for _, appConfig := range config.Apps {
+		if err := appConfig.Python.CheckInterpreter(); err != nil {
+			return nil, fmt.Errorf("invalid python config for app %s: %v", appConfig.Name, err)
+		}
+	}
+	return config, nil
+}
+
+// assignPorts picks the port of every app in the config, on a new allocator. Pinned
+// ports are taken as given. The other apps keep the port they have in current if it is
+// still theirs to take, and otherwise get a free port from the range.
+func (m *Manager) assignPorts(config *AppsConfig, current map[string]int) (map[string]int, *PortAllocator, error) {
+	allocator := NewPortAllocator(m.Options.BasePort, m.Options.MaxPort)
+	ports := make(map[string]int)
+	err := allocator.Reserve(config.ManagementUi.Name, m.Options.ManagementPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	ports[config.ManagementUi.Name] = m.Options.ManagementPort
+	for _, appConfig := range config.Apps {
+		if appConfig.Port == 0 {
+			continue
+		}
+		err := allocator.Reserve(appConfig.Name, appConfig.Port)
+		if err != nil {
+			return nil, nil, fmt.Errorf("app %s: %v", appConfig.Name, err)
+		}
+		ports[appConfig.Name] = appConfig.Port
+	}
+	// apps keep their ports before new apps get theirs, whatever the order in the config
+	for _, appConfig := range config.Apps {
+		if appConfig.Port != 0 {
+			continue
+		}
+		if port, exists := current[appConfig.Name]; exists && allocator.Reserve(appConfig.Name, port) == nil {
+			ports[appConfig.Name] = port
+		}
+	}
+	for _, appConfig := range config.Apps {
+		if _, assigned := ports[appConfig.Name]; assigned {
+			continue
+		}
+		port, err := allocator.Allocate(appConfig.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("app %s: %v", appConfig.Name, err)
+		}
+		ports[appConfig.Name] = port
+	}
+	return ports, allocator, nil
+}
+
+// StartApp starts the app. If another process took the app's port while it was stopped,
+// the app is moved to a free port first, unless its port is pinned in the config.
+func (m *Manager) StartApp(app *App) error {
+	if app.GetStatus().IsStopped() && !PortFree(app.PreferredPort) {
+		err := m.movePort(app)
+		if err != nil {
+			return err
+		}
+	}
+	return m.RunManager.RunApp(app)
+}
 
-	var config AppsConfig
-	err = yaml.Unmarshal(yamlFile, &config)
+func (m *Manager) movePort(app *App) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var config *Config
+	for _, appConfig := range m.AppsConfig.Apps {
+		if appConfig.Name == app.ID {
+			config = appConfig
+		}
+	}
+	if config == nil || config.Port != 0 {
+		// pinned ports stay put, starting reports the port as taken
+		return nil
+	}
+	port, err := m.PortAllocator.Allocate(app.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// Load apps from YAML file
+	fmt.Println("Port", app.PreferredPort, "of app", app.Name, "is in use by another process, moving to port", port)
+	err = config.configure(app, port)
+	if err != nil {
+		return err
+	}
+	m.AppPorts[app.ID] = port
+	return nil
+}
 
-	if config.Apps == nil {
-		return nil, fmt.Errorf("no apps found in config")
+func NewManagerFromYaml(filename string, options ManagerOptions) (*Manager, error) {
+	manager := NewManager(options)
+
+	// Load apps from YAML file
+	parsed, err := LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := *parsed
+	ports, allocator, err := manager.assignPorts(&config, nil)
+	if err != nil {
+		return nil, err
 	}
-	startingPort := 8001
 	for _, appConfig := range config.Apps {
-		app, err := appConfig.ToApp(startingPort)
+		app, err := manager.newApp(appConfig, ports[appConfig.Name])
 		if err != nil {
 			return nil, err
 		}
 		manager.AllApps = append(manager.AllApps, app)
-		manager.AppPorts[app.ID] = startingPort
-		startingPort++
 	}
 	manager.AppsConfig = &config
+	manager.ConfigFile = filename
+	manager.AppPorts = ports
+	manager.PortAllocator = allocator
 
-	managementApp, err := config.ManagementUi.ToApp(ManagementPort)
+	managementApp, err := manager.newManagementApp(config.ManagementUi)
 	if err != nil {
 		return nil, err
 	}
-	manager.AppPorts[managementApp.ID] = ManagementPort
 	manager.ManagementApp = managementApp
 
 	return manager, nil
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// runTestApp starts the app with the run manager and waits for its process to be up.
+func runTestApp(t *testing.T, manager *RunManager, app *App) {
+	t.Helper()
+	err := manager.RunApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, app.Name+" to launch", func() bool {
+		return len(app.supervisedPIDs()) > 0
+	})
+}
+
+func TestShutdownStopsLastAppLast(t *testing.T) {
+	manager := NewAppRunManager(0)
+	last := newTestApp(t, "shutdown-ui", "sleep 30")
+	others := []*App{
+		newTestApp(t, "shutdown-a", "sleep 30"),
+		newTestApp(t, "shutdown-b", "sleep 30"),
+	}
+	for _, app := range append(others, last) {
+		runTestApp(t, manager, app)
+	}
+
+	err := manager.Shutdown(last, 20*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastInfo := last.Info()
+	if lastInfo.Status != StatusTerminated {
+		t.Errorf("%s is %s, want terminated", last.Name, lastInfo.Status)
+	}
+	for _, app := range others {
+		info := app.Info()
+		if info.Status != StatusTerminated {
+			t.Errorf("%s is %s, want terminated", app.Name, info.Status)
+		}
+		if info.StoppedAt == nil || lastInfo.StoppedAt == nil || lastInfo.StoppedAt.Before(*info.StoppedAt) {
+			t.Errorf("%s stopped at %v, after %s at %v", app.Name, info.StoppedAt, last.Name, lastInfo.StoppedAt)
+		}
+	}
+	if len(manager.ListRunningApps()) != 0 {
+		t.Errorf("apps still active after shutdown: %v", manager.ListRunningApps())
+	}
+	if manager.RunApp(others[0]) == nil {
+		t.Error("RunApp succeeded after shutdown")
+	}
+}
+
+func TestBeginShutdownKeepsExitingAppsDown(t *testing.T) {
+	manager := NewAppRunManager(0)
+	app := newTestApp(t, "shutdown-crash", "sleep 0.5; exit 1")
+	app.RestartPolicy = &RestartPolicy{Policy: RestartAlways, Backoff: Duration(10 * time.Millisecond)}
+	runTestApp(t, manager, app)
+
+	manager.BeginShutdown()
+	waitFor(t, 5*time.Second, "the app to exit", func() bool {
+		info := app.Info()
+		return info.StoppedAt != nil
+	})
+	time.Sleep(100 * time.Millisecond)
+	info := app.Info()
+	if info.RestartCount != 0 || info.Status == StatusFailed {
+		t.Errorf("app exiting during shutdown: restarts %d, status %s, want no restart and not failed",
+			info.RestartCount, info.Status)
+	}
+
+	err := manager.Shutdown(nil, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := app.GetStatus(); status != StatusTerminated {
+		t.Errorf("status after shutdown = %s, want terminated", status)
+	}
+}
+
+func TestRunAppWhileLaunching(t *testing.T) {
+	manager := NewAppRunManager(1)
+	app := newTestApp(t, "launching", "sleep 30")
+	// as startQueued does before stopping the apps evicted for it
+	manager.Mutex.Lock()
+	manager.activate(app, nil)
+	manager.Mutex.Unlock()
+
+	err := manager.RunApp(app)
+	if err != nil {
+		t.Errorf("RunApp of a launching app = %v, want nil", err)
+	}
+	if status := app.GetStatus(); !status.IsStopped() {
+		t.Errorf("RunApp started the launching app, status %s", status)
+	}
+	manager.Mutex.Lock()
+	_, err = manager.makeRoom(appWith("other", 0, 0))
+	manager.Mutex.Unlock()
+	if err == nil {
+		t.Error("makeRoom evicts an app that is being launched")
+	}
+
+	err = manager.launch(app, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the app to launch", func() bool {
+		return len(app.supervisedPIDs()) > 0
+	})
+	err = manager.StopApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailedStartFreesSlot(t *testing.T) {
+	manager := NewAppRunManager(1)
+	failing := newTestApp(t, "port-taken", "sleep 30")
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", failing.PreferredPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if manager.RunApp(failing) == nil {
+		t.Fatal("RunApp succeeded on a port that is taken")
+	}
+	if _, err := manager.GetRunningApp(failing.ID); err == nil {
+		t.Error("the app that failed to start is still active")
+	}
+
+	next := newTestApp(t, "after-failure", "sleep 30")
+	runTestApp(t, manager, next)
+	err = manager.StopApp(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	cmd "github.com/ShinyTrinkets/overseer"
+	"os"
+	"path/filepath"
+)
+
+// NodeInstall describes how the dependencies of a Node.js app get installed.
+type NodeInstall struct {
+	PackageManager string
+	Args           []string
+}
+
+func (a *App) isNodejs() bool {
+	return a.Type == TypeNodejs
+}
+
+func (a *App) nodeBinPath() string {
+	return filepath.Join(a.RootDir, "node_modules", ".bin")
+}
+
+func (a *App) nodejsCmdOptions() cmd.Options {
+	path := os.Getenv("PATH")
+	return a.cmdOptions(
+		"PATH=" + a.nodeBinPath() + ":" + path,
+	)
+}
+
+// nodeInstall picks the install command based on the lockfile checked into the app.
+// It returns nil when the app has no package.json and there is nothing to install.
+func (a *App) nodeInstall() *NodeInstall {
+	if !a.hasFile("package.json") {
+		return nil
+	}
+	switch {
+	case a.hasFile("pnpm-lock.yaml"):
+		return &NodeInstall{PackageManager: "pnpm", Args: []string{"install", "--frozen-lockfile"}}
+	case a.hasFile("yarn.lock"):
+		return &NodeInstall{PackageManager: "yarn", Args: []string{"install", "--frozen-lockfile"}}
+	case a.hasFile("package-lock.json"), a.hasFile("npm-shrinkwrap.json"):
+		return &NodeInstall{PackageManager: "npm", Args: []string{"ci"}}
+	}
+	return &NodeInstall{PackageManager: "npm", Args: []string{"install"}}
+}
+
+func (a *App) installNodeDependencies() error {
+	if !a.isNodejs() {
+		return nil
+	}
+	install := a.nodeInstall()
+	if install == nil {
+		fmt.Println("No package.json found, skipping dependency install")
+		return nil
+	}
+	fmt.Println("Installing node dependencies with", install.PackageManager)
+	cmdOptions := a.nodejsCmdOptions()
+	return a.runSetupStep("installNodeDependencies", install.PackageManager, install.Args,
+		cmdOptions)
+}
+
+func (a *App) setupNodejs() error {
+	if !a.isNodejs() {
+		return nil
+	}
+	fmt.Println("Setting up Node.js app")
+	err := a.installNodeDependencies()
+	if err != nil {
+		fmt.Println("Error installing node dependencies")
+		return err
+	}
+	return nil
+}
+
+func (a *App) startNodejs() error {
+	if !a.isNodejs() {
+		return nil
+	}
+	err := a.setup()
+	if err != nil {
+		fmt.Println("Error setting up app")
+		return err
+	}
+	fmt.Println("Starting app command")
+	cmdOptions := a.nodejsCmdOptions()
+	return a.launch(cmdOptions)
+}
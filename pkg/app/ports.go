@@ -1,51 +1,335 @@
 package app
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"os/exec"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-// findPID finds the PID of the process using the given port.
-func findPID(port int) (int, error) {
-	cmd := exec.Command("lsof", "-i", fmt.Sprintf(":%d", port))
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+// killGracePeriod is how long a process gets to exit after SIGTERM before it is killed.
+const killGracePeriod = 5 * time.Second
+
+// PortAllocator hands out ports from a range to apps. A port is only handed out if no
+// other app holds it and nothing on the host is listening on it.
+type PortAllocator struct {
+	Min int // First port of the range
+	Max int // Last port of the range
+
+	owners map[int]string // App ID holding each port
+	mutex  sync.Mutex
+}
+
+func NewPortAllocator(min, max int) *PortAllocator {
+	return &PortAllocator{
+		Min:    min,
+		Max:    max,
+		owners: make(map[int]string),
+	}
+}
+
+// Allocate returns a free port from the range for the app, releasing the ports the app
+// held before.
+func (p *PortAllocator) Allocate(id string) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.release(id)
+	for port := p.Min; port <= p.Max; port++ {
+		if _, taken := p.owners[port]; taken || !PortFree(port) {
+			continue
+		}
+		p.owners[port] = id
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port left in range %d-%d", p.Min, p.Max)
+}
+
+// Reserve gives the app the given port, which may be outside the range, releasing the
+// ports the app held before. It fails if another app holds the port. It does not check
+// whether the port is free on the host.
+func (p *PortAllocator) Reserve(id string, port int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if owner, taken := p.owners[port]; taken && owner != id {
+		return fmt.Errorf("port %d is already used by app %s", port, owner)
+	}
+	p.release(id)
+	p.owners[port] = id
+	return nil
+}
+
+// Release frees the ports held by the app.
+func (p *PortAllocator) Release(id string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.release(id)
+}
+
+func (p *PortAllocator) release(id string) {
+	for port, owner := range p.owners {
+		if owner == id {
+			delete(p.owners, port)
+		}
+	}
+}
+
+// PortFree reports whether nothing is listening on the given port.
+func PortFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		return 0, fmt.Errorf("could not execute lsof command: %v", err)
+		return false
 	}
+	listener.Close()
+	return true
+}
+
+// tcpListen is the state of a listening socket in /proc/net/tcp.
+const tcpListen = "0A"
 
-	lines := strings.Split(out.String(), "\n")
-	if len(lines) < 2 {
-		return 0, fmt.Errorf("no process found using port %d", port)
+// listeningInodes returns the inodes of the sockets listening on the given port, read
+// from /proc/net/tcp and /proc/net/tcp6.
+func listeningInodes(port int) (map[string]bool, error) {
+	inodes := make(map[string]bool)
+	found := false
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		found = true
+		lines := strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != tcpListen {
+				continue
+			}
+			_, hexPort, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			localPort, err := strconv.ParseInt(hexPort, 16, 32)
+			if err != nil || int(localPort) != port {
+				continue
+			}
+			inodes[fields[9]] = true
+		}
 	}
+	if !found {
+		return nil, fmt.Errorf("could not read the socket tables in /proc/net")
+	}
+	return inodes, nil
+}
 
-	fields := strings.Fields(lines[1])
-	if len(fields) < 2 {
-		return 0, fmt.Errorf("could not parse output for port %d", port)
+// portHolders returns the PIDs of the processes listening on the given port. Processes
+// whose file descriptors we may not read are missed.
+func portHolders(port int) ([]int, error) {
+	inodes, err := listeningInodes(port)
+	if err != nil || len(inodes) == 0 {
+		return nil, err
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
 	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, isSocket := strings.CutPrefix(link, "socket:[")
+			if isSocket && inodes[strings.TrimSuffix(inode, "]")] {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
 
-	pid, err := strconv.Atoi(fields[1])
+// statFields returns the fields of /proc/<pid>/stat that follow the command name,
+// starting with the state.
+func statFields(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
 	if err != nil {
-		return 0, fmt.Errorf("invalid PID: %v", err)
+		return nil, err
 	}
+	// the command name is in parentheses and may itself contain spaces or parentheses
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("could not parse /proc/%d/stat", pid)
+	}
+	return fields, nil
+}
 
-	return pid, nil
+// processStat returns the parent PID and process group of a process, read from
+// /proc/<pid>/stat.
+func processStat(pid int) (int, int, error) {
+	fields, err := statFields(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	pgid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	return ppid, pgid, nil
+}
+
+// processStartTime returns when the process started, in clock ticks since boot. Along
+// with the PID, it tells a process apart from a later one that reuses its PID.
+func processStartTime(pid int) (uint64, error) {
+	fields, err := statFields(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// processStarts notes the start time of each of the processes, see sameProcess.
+func processStarts(pids map[int]bool) map[int]uint64 {
+	starts := make(map[int]uint64)
+	for pid := range pids {
+		start, err := processStartTime(pid)
+		if err == nil {
+			starts[pid] = start
+		}
+	}
+	return starts
 }
 
-// killProcess kills the process with the given PID.
-func killProcess(pid int) error {
-	cmd := exec.Command("kill", "-9", strconv.Itoa(pid))
-	return cmd.Run()
+// sameProcess reports whether pid is still the process noted in starts, rather than
+// gone or reused by another process since.
+func sameProcess(pid int, starts map[int]uint64) bool {
+	noted, ok := starts[pid]
+	if !ok {
+		return false
+	}
+	start, err := processStartTime(pid)
+	return err == nil && start == noted
 }
 
-func KillPort(port int) error {
-	pid, err := findPID(port)
+// processTree returns the given process, its descendants and the members of its process
+// group. Supervised apps lead their own process group, so this is everything the app
+// started, unless a process moved itself into a new group and got orphaned.
+func processTree(root int) map[int]bool {
+	tree := map[int]bool{root: true}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return tree
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		ppid, pgid, err := processStat(pid)
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+		if pgid == root {
+			tree[pid] = true
+		}
+	}
+	queue := []int{root}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			tree[child] = true
+			queue = append(queue, child)
+		}
+	}
+	return tree
+}
+
+// signalStrays sends sig to the processes of the tree that left the process groups of
+// the roots, which signalling the groups does not reach.
+func signalStrays(tree map[int]bool, roots map[int]bool, sig syscall.Signal) {
+	for pid := range tree {
+		_, pgid, err := processStat(pid)
+		if err != nil || roots[pgid] {
+			continue
+		}
+		syscall.Kill(pid, sig)
+	}
+}
+
+// processAlive reports whether the process still exists.
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// terminateProcess asks the process to exit with SIGTERM, and kills it with SIGKILL if
+// it is still around after the grace period.
+func terminateProcess(pid int, grace time.Duration) error {
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return killProcess(pid)
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	err = syscall.Kill(pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// KillPort terminates the processes listening on the given port that belong to the
+// given process tree, see processTree. Processes outside the tree are never touched; an
+// error reports them.
+func KillPort(port int, tree map[int]bool) error {
+	pids, err := portHolders(port)
+	if err != nil {
+		return err
+	}
+	var foreign []string
+	for _, pid := range pids {
+		if !tree[pid] {
+			foreign = append(foreign, strconv.Itoa(pid))
+			continue
+		}
+		fmt.Println("Terminating process", pid, "still listening on port", port)
+		err := terminateProcess(pid, killGracePeriod)
+		if err != nil {
+			return fmt.Errorf("could not terminate process %d: %v", pid, err)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("port %d is held by process(es) %s, which the app did not start", port, strings.Join(foreign, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestPortAllocator(t *testing.T) {
+	allocator := NewPortAllocator(18950, 18953)
+	allocate := func(id string, want int) {
+		t.Helper()
+		port, err := allocator.Allocate(id)
+		if err != nil || port != want {
+			t.Fatalf("Allocate(%s) = %d, %v, want %d", id, port, err, want)
+		}
+	}
+	allocate("a", 18950)
+	allocate("b", 18951)
+	// allocating again hands the app's own port back first
+	allocate("a", 18950)
+
+	// ports something on the host listens on are skipped
+	listener, err := net.Listen("tcp", ":18952")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	allocate("c", 18953)
+	if port, err := allocator.Allocate("d"); err == nil {
+		t.Fatalf("Allocate(d) = %d in a full range, want an error", port)
+	}
+
+	allocator.Release("b")
+	allocate("d", 18951)
+
+	if err := allocator.Reserve("e", 18950); err == nil {
+		t.Error("Reserve gave e the port of a")
+	}
+	if err := allocator.Reserve("a", 18950); err != nil {
+		t.Errorf("Reserve of the app's own port: %v", err)
+	}
+	// reserving moves the app, freeing its port in the range
+	if err := allocator.Reserve("a", 9000); err != nil {
+		t.Errorf("Reserve of a port outside the range: %v", err)
+	}
+	if err := allocator.Reserve("e", 9000); err == nil {
+		t.Error("Reserve gave e the port a moved to")
+	}
+	allocate("e", 18950)
+}
+
+func TestAssignPorts(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.BasePort, options.MaxPort, options.ManagementPort = 18960, 18969, 18959
+	manager := &Manager{Options: options}
+	config := func(pinned map[string]int, names ...string) *AppsConfig {
+		config := &AppsConfig{ManagementUi: &Config{Name: "ui"}}
+		for _, name := range names {
+			config.Apps = append(config.Apps, &Config{Name: name, Port: pinned[name]})
+		}
+		return config
+	}
+	tests := []struct {
+		name    string
+		config  *AppsConfig
+		current map[string]int
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name:   "range in config order",
+			config: config(nil, "a", "b"),
+			want:   map[string]int{"ui": 18959, "a": 18960, "b": 18961},
+		},
+		{
+			name:   "pinned",
+			config: config(map[string]int{"b": 18960}, "a", "b"),
+			want:   map[string]int{"ui": 18959, "a": 18961, "b": 18960},
+		},
+		{
+			name:    "current ports are kept before new apps are placed",
+			config:  config(nil, "new", "a", "b"),
+			current: map[string]int{"a": 18961, "b": 18960},
+			want:    map[string]int{"ui": 18959, "new": 18962, "a": 18961, "b": 18960},
+		},
+		{
+			name:    "pinned ports win over current ones",
+			config:  config(map[string]int{"b": 18960}, "a", "b"),
+			current: map[string]int{"a": 18960, "b": 18961},
+			want:    map[string]int{"ui": 18959, "a": 18961, "b": 18960},
+		},
+		{
+			name:    "pinned to the management port",
+			config:  config(map[string]int{"a": 18959}, "a"),
+			wantErr: true,
+		},
+		{
+			name:    "pinned twice",
+			config:  config(map[string]int{"a": 18965, "b": 18965}, "a", "b"),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ports, _, err := manager.assignPorts(test.config, test.current)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("assignPorts = %v, want an error", ports)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ports) != fmt.Sprint(test.want) {
+				t.Errorf("assignPorts = %v, want %v", ports, test.want)
+			}
+		})
+	}
+}
+
+func TestSameProcess(t *testing.T) {
+	child := exec.Command("sleep", "30")
+	err := child.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := child.Process.Pid
+	starts := processStarts(map[int]bool{pid: true, os.Getpid(): true})
+	if !sameProcess(pid, starts) {
+		t.Error("sameProcess is false for a running process")
+	}
+	if !sameProcess(os.Getpid(), starts) {
+		t.Error("sameProcess is false for the test process")
+	}
+
+	reused := map[int]uint64{pid: starts[pid] + 1}
+	if sameProcess(pid, reused) {
+		t.Error("sameProcess is true for a process with another start time")
+	}
+	if sameProcess(pid, map[int]uint64{}) {
+		t.Error("sameProcess is true for a process that was not noted")
+	}
+
+	child.Process.Kill()
+	child.Wait()
+	if sameProcess(pid, starts) {
+		t.Error("sameProcess is true for a process that is gone")
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// QueuedError is returned when an app cannot start for lack of room and waits in the
+// start queue instead. It starts once enough apps stop.
+type QueuedError struct {
+	Position int   // Place in the queue, from 1
+	Reason   error // Why the app could not start right away
+}
+
+func (e *QueuedError) Error() string {
+	return fmt.Sprintf("%v, queued at position %d", e.Reason, e.Position)
+}
+
+// QueueEntry describes an app waiting in the start queue.
+type QueueEntry struct {
+	Name     string    `json:"name"`
+	Position int       `json:"position"`
+	Priority int       `json:"priority,omitempty"`
+	QueuedAt time.Time `json:"queuedAt"`
+	Reason   string    `json:"reason"`
+}
+
+// queuedStart is a start request waiting for room.
+type queuedStart struct {
+	app      *App
+	queuedAt time.Time
+	reason   error
+	previous Status // Status of the app before it was queued, restored when it leaves the queue
+}
+
+// enqueue adds the app to the start queue, behind the apps of the same or a higher
+// priority, and returns its position. An app already in the queue keeps its place. The
+// caller holds am.Mutex.
+func (am *RunManager) enqueue(app *App, reason error) int {
+	if position := am.queuePosition(app); position > 0 {
+		am.queue[position-1].reason = reason
+		return position
+	}
+	app.mutex.Lock()
+	previous := app.Status
+	app.Status = StatusQueued
+	app.mutex.Unlock()
+	am.queue = append(am.queue, &queuedStart{app: app, queuedAt: time.Now(), reason: reason, previous: previous})
+	sort.SliceStable(am.queue, func(i, j int) bool {
+		return am.queue[i].app.Priority > am.queue[j].app.Priority
+	})
+	return am.queuePosition(app)
+}
+
+// queuePosition returns the place of the app in the start queue, from 1, or zero if it
+// is not queued. The caller holds am.Mutex.
+func (am *RunManager) queuePosition(app *App) int {
+	for i, entry := range am.queue {
+		if entry.app == app {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// dequeue takes the app out of the start queue and restores its status. The caller
+// holds am.Mutex.
+func (am *RunManager) dequeue(app *App) bool {
+	position := am.queuePosition(app)
+	if position == 0 {
+		return false
+	}
+	entry := am.queue[position-1]
+	am.queue = append(am.queue[:position-1], am.queue[position:]...)
+	app.transitionStatus(entry.previous, StatusQueued)
+	return true
+}
+
+// CancelStart takes the app out of the start queue. It reports whether the app was
+// queued.
+func (am *RunManager) CancelStart(app *App) bool {
+	am.Mutex.Lock()
+	defer am.Mutex.Unlock()
+	if !am.dequeue(app) {
+		return false
+	}
+	fmt.Println("Cancelled queued start of app", app.Name)
+	return true
+}
+
+// IsQueued reports whether the app is waiting in the start queue.
+func (am *RunManager) IsQueued(app *App) bool {
+	am.Mutex.Lock()
+	defer am.Mutex.Unlock()
+	return am.queuePosition(app) > 0
+}
+
+// Queue returns the apps waiting in the start queue, in the order they will start.
+func (am *RunManager) Queue() []QueueEntry {
+	am.Mutex.Lock()
+	defer am.Mutex.Unlock()
+	entries := make([]QueueEntry, 0, len(am.queue))
+	for i, entry := range am.queue {
+		entries = append(entries, QueueEntry{
+			Name:     entry.app.Name,
+			Position: i + 1,
+			Priority: entry.app.Priority,
+			QueuedAt: entry.queuedAt,
+			Reason:   entry.reason.Error(),
+		})
+	}
+	return entries
+}
+
+// startQueued starts apps from the head of the queue for as long as there is room. The
+// head blocks the apps behind it, so a big app is not starved by small ones. It returns
+// the result of starting each app it took from the queue.
+func (am *RunManager) startQueued() map[*App]error {
+	started := make(map[*App]error)
+	for {
+		am.Mutex.Lock()
+		if am.shuttingDown || len(am.queue) == 0 {
+			am.Mutex.Unlock()
+			return started
+		}
+		entry := am.queue[0]
+		evicted, err := am.makeRoom(entry.app)
+		if err != nil {
+			entry.reason = err
+			am.Mutex.Unlock()
+			return started
+		}
+		am.dequeue(entry.app)
+		am.activate(entry.app, evicted)
+		am.Mutex.Unlock()
+
+		started[entry.app] = am.launch(entry.app, evicted)
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// queueNames returns the names of the queued apps, in the order they will start.
+func queueNames(manager *RunManager) []string {
+	var names []string
+	for _, entry := range manager.Queue() {
+		names = append(names, fmt.Sprintf("%d:%s", entry.Position, entry.Name))
+	}
+	return names
+}
+
+func TestStartQueue(t *testing.T) {
+	manager := NewAppRunManager(1)
+	busy := appWith("busy", 0, 0)
+	busy.Pinned = true
+	busy.Status = StatusReady
+	manager.ActiveApps[busy.ID] = busy
+
+	apps := make(map[string]*App)
+	for _, spec := range []struct {
+		name         string
+		priority     int
+		wantPosition int
+	}{
+		{"first", 0, 1},
+		{"second", 0, 2},
+		{"urgent", 5, 1},
+		{"important", 1, 2},
+		{"third", 0, 5},
+		{"urgent2", 5, 2},
+	} {
+		app := appWith(spec.name, 0, 0)
+		app.Priority = spec.priority
+		app.Status = StatusTerminated
+		apps[spec.name] = app
+		err := manager.RunApp(app)
+		var queued *QueuedError
+		if !errors.As(err, &queued) {
+			t.Fatalf("RunApp(%s) = %v, want it queued", spec.name, err)
+		}
+		if queued.Position != spec.wantPosition {
+			t.Errorf("RunApp(%s) queued at %d, want %d", spec.name, queued.Position, spec.wantPosition)
+		}
+		if status := app.GetStatus(); status != StatusQueued {
+			t.Errorf("%s is %s, want queued", spec.name, status)
+		}
+	}
+	want := "[1:urgent 2:urgent2 3:important 4:first 5:second 6:third]"
+	if names := fmt.Sprint(queueNames(manager)); names != want {
+		t.Fatalf("queue = %s, want %s", names, want)
+	}
+
+	// asking again keeps the app's place
+	err := manager.RunApp(apps["second"])
+	var queued *QueuedError
+	if !errors.As(err, &queued) || queued.Position != 5 {
+		t.Errorf("RunApp(second) again = %v, want it still at 5", err)
+	}
+
+	if !manager.CancelStart(apps["important"]) {
+		t.Error("CancelStart(important) = false for a queued app")
+	}
+	if manager.IsQueued(apps["important"]) {
+		t.Error("important is still queued after CancelStart")
+	}
+	if status := apps["important"].GetStatus(); status != StatusTerminated {
+		t.Errorf("important is %s after CancelStart, want its status from before, terminated", status)
+	}
+	if manager.CancelStart(apps["important"]) {
+		t.Error("CancelStart(important) = true for an app that is not queued")
+	}
+	if manager.CancelStart(busy) {
+		t.Error("CancelStart(busy) = true for a running app")
+	}
+	want = "[1:urgent 2:urgent2 3:first 4:second 5:third]"
+	if names := fmt.Sprint(queueNames(manager)); names != want {
+		t.Errorf("queue after CancelStart = %s, want %s", names, want)
+	}
+
+	// nothing starts while the app in the way is pinned
+	if started := manager.startQueued(); len(started) != 0 {
+		t.Errorf("startQueued started %d apps without room", len(started))
+	}
+	if reason := manager.Queue()[0].Reason; reason != "app limit reached" {
+		t.Errorf("reason of the head of the queue = %q, want app limit reached", reason)
+	}
+}
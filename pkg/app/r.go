@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	cmd "github.com/ShinyTrinkets/overseer"
+	"os"
+	"path/filepath"
+)
+
+const defaultCranRepo = "https://cloud.r-project.org"
+
+func (a *App) isR() bool {
+	return a.Type == TypeR
+}
+
+// rLibraryPath is the per-app library dependencies are restored into.
+func (a *App) rLibraryPath() string {
+	return filepath.Join(a.RootDir, ".Rlib")
+}
+
+func (a *App) cranRepo() string {
+	if repo := os.Getenv("MULTI_APP_CRAN_REPO"); repo != "" {
+		return repo
+	}
+	return defaultCranRepo
+}
+
+func (a *App) rCmdOptions() cmd.Options {
+	path := os.Getenv("PATH")
+	return a.cmdOptions(
+		"PATH="+path,
+		"R_LIBS_USER="+a.rLibraryPath(),
+		// keep renv's project autoloader from swapping out the library we restored into
+		"RENV_CONFIG_AUTOLOADER_ENABLED=FALSE",
+		"SHINY_PORT="+fmt.Sprintf("%d", a.PreferredPort),
+	)
+}
+
+// rRestoreExpr returns the R expression that restores the app's dependencies, or an
+// empty string when the app declares none.
+func (a *App) rRestoreExpr() string {
+	lib := a.rLibraryPath()
+	repo := a.cranRepo()
+	switch {
+	case a.hasFile("renv.lock"):
+		return fmt.Sprintf(`if (!requireNamespace("renv", quietly = TRUE)) install.packages("renv", lib = %q, repos = %q); `+
+			`renv::restore(lockfile = "renv.lock", library = %q, prompt = FALSE)`, lib, repo, lib)
+	case a.hasFile("DESCRIPTION"):
+		return fmt.Sprintf(`if (!requireNamespace("remotes", quietly = TRUE)) install.packages("remotes", lib = %q, repos = %q); `+
+			`remotes::install_deps(".", lib = %q, repos = %q, upgrade = "never")`, lib, repo, lib, repo)
+	}
+	return ""
+}
+
+func (a *App) restoreRDependencies() error {
+	if !a.isR() {
+		return nil
+	}
+	expr := a.rRestoreExpr()
+	if expr == "" {
+		fmt.Println("No renv.lock or DESCRIPTION found, skipping dependency restore")
+		return nil
+	}
+	err := os.MkdirAll(a.rLibraryPath(), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Restoring R dependencies")
+	cmdOptions := a.rCmdOptions()
+	return a.runSetupStep("restoreRDependencies", "Rscript", []string{"-e", expr},
+		cmdOptions)
+}
+
+func (a *App) setupR() error {
+	if !a.isR() {
+		return nil
+	}
+	fmt.Println("Setting up R app")
+	err := a.restoreRDependencies()
+	if err != nil {
+		fmt.Println("Error restoring R dependencies")
+		return err
+	}
+	return nil
+}
+
+func (a *App) startR() error {
+	if !a.isR() {
+		return nil
+	}
+	err := a.setup()
+	if err != nil {
+		fmt.Println("Error setting up app")
+		return err
+	}
+	fmt.Println("Starting app command")
+	cmdOptions := a.rCmdOptions()
+	return a.launch(cmdOptions)
+}
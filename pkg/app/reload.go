@@ -0,0 +1,202 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// configDebounce collapses the burst of events editors produce when saving a file.
+const configDebounce = 500 * time.Millisecond
+
+// ReloadResult lists, by app name, what a reload did.
+type ReloadResult struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Restarted []string `json:"restarted"` // config changed and the app was running
+	Updated   []string `json:"updated"`   // config changed and the app was not running
+	Unchanged []string `json:"unchanged"`
+}
+
+// ReloadFromYaml re-reads the config file and applies it with Reload. If the file is
+// invalid, the running config is kept.
+func (m *Manager) ReloadFromYaml() (*ReloadResult, error) {
+	config, err := LoadConfig(m.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	return m.Reload(config)
+}
+
+// Reload applies a new config to the running manager. Apps are matched by name: new apps
+// are added, apps missing from the config are stopped and removed, and apps whose config
+// changed are replaced and restarted if they were running. Untouched apps keep running.
+// Repos are not staged again.
+func (m *Manager) Reload(config *AppsConfig) (*ReloadResult, error) {
+	// the watcher and the API may reload at the same time
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+	result := &ReloadResult{}
+	var toStop, toStart []*App
+
+	m.mutex.Lock()
+	oldConfigs := make(map[string]*Config)
+	for _, appConfig := range m.AppsConfig.Apps {
+		oldConfigs[appConfig.Name] = appConfig
+	}
+	oldApps := make(map[string]*App)
+	for _, app := range m.AllApps {
+		oldApps[app.ID] = app
+	}
+
+	ports, allocator, err := m.assignPorts(config, m.AppPorts)
+	if err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	apps := make([]*App, 0, len(config.Apps))
+	for _, appConfig := range config.Apps {
+		port := ports[appConfig.Name]
+		oldConfig, exists := oldConfigs[appConfig.Name]
+		if exists && reflect.DeepEqual(oldConfig, appConfig) && m.AppPorts[appConfig.Name] == port {
+			apps = append(apps, oldApps[appConfig.Name])
+			result.Unchanged = append(result.Unchanged, appConfig.Name)
+			continue
+		}
+
+		app, err := m.newApp(appConfig, port)
+		if err != nil {
+			m.mutex.Unlock()
+			return nil, fmt.Errorf("app %s: %v", appConfig.Name, err)
+		}
+		apps = append(apps, app)
+
+		if !exists {
+			result.Added = append(result.Added, app.Name)
+			continue
+		}
+		oldApp := oldApps[appConfig.Name]
+		if _, err := m.RunManager.GetRunningApp(oldApp.ID); err == nil || m.RunManager.IsQueued(oldApp) {
+			toStop = append(toStop, oldApp)
+			toStart = append(toStart, app)
+			result.Restarted = append(result.Restarted, app.Name)
+		} else {
+			result.Updated = append(result.Updated, app.Name)
+		}
+	}
+	for _, oldConfig := range m.AppsConfig.Apps {
+		if _, exists := ports[oldConfig.Name]; exists {
+			continue
+		}
+		result.Removed = append(result.Removed, oldConfig.Name)
+		if _, err := m.RunManager.GetRunningApp(oldConfig.Name); err == nil || m.RunManager.IsQueued(oldApps[oldConfig.Name]) {
+			toStop = append(toStop, oldApps[oldConfig.Name])
+		}
+	}
+
+	managementApp := m.ManagementApp
+	restageUI := false
+	if !reflect.DeepEqual(m.AppsConfig.ManagementUi, config.ManagementUi) {
+		newManagementApp, err := m.newManagementApp(config.ManagementUi)
+		if err != nil {
+			m.mutex.Unlock()
+			return nil, fmt.Errorf("ui: %v", err)
+		}
+		toStop = append(toStop, managementApp)
+		toStart = append(toStart, newManagementApp)
+		result.Restarted = append(result.Restarted, newManagementApp.Name)
+		managementApp = newManagementApp
+		restageUI = true
+	}
+
+	m.AllApps = apps
+	m.AppPorts = ports
+	m.AppsConfig = config
+	m.ManagementApp = managementApp
+	m.PortAllocator = allocator
+	m.mutex.Unlock()
+
+	// stopping waits for the apps to wind down, so it happens outside the lock
+	for _, app := range toStop {
+		if m.RunManager.CancelStart(app) {
+			continue
+		}
+		if _, err := m.RunManager.GetRunningApp(app.ID); err != nil {
+			continue
+		}
+		err := m.RunManager.StopApp(app)
+		if err != nil {
+			fmt.Println("Error stopping app", app.Name, err)
+		}
+	}
+	if restageUI {
+		err := m.StageUICode()
+		if err != nil {
+			return result, err
+		}
+	}
+	for _, app := range toStart {
+		err := m.StartApp(app)
+		var queued *QueuedError
+		if errors.As(err, &queued) {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("could not restart app %s: %v", app.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// WatchConfig reloads the config whenever its file changes, until stop is closed. Invalid
+// changes are reported and otherwise ignored.
+func (m *Manager) WatchConfig(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the directory, editors often replace the file rather than write to it
+	err = watcher.Add(filepath.Dir(m.ConfigFile))
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	configFile := filepath.Clean(m.ConfigFile)
+
+	go func() {
+		defer watcher.Close()
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != configFile || event.Has(fsnotify.Chmod) {
+					continue
+				}
+				debounce = time.After(configDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Println("Error watching config", err)
+			case <-debounce:
+				debounce = nil
+				fmt.Println("Config changed, reloading", configFile)
+				result, err := m.ReloadFromYaml()
+				if err != nil {
+					fmt.Println("Error reloading config", err)
+					continue
+				}
+				fmt.Printf("Config reloaded: %+v\n", *result)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestConfig writes a config of command apps running the given shell commands, by
+// app name, and returns its path.
+func writeTestConfig(t *testing.T, dir string, commands map[string]string) string {
+	t.Helper()
+	var config strings.Builder
+	fmt.Fprintf(&config, "version: 1\nui:\n  name: ui\n  command: sleep 30\n  routePath: /\n  codePath: %s\n  type: command\napps:\n", dir)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&config, "  - name: %s\n    command: %s\n    routePath: /%s\n    codePath: %s\n    type: command\n",
+			name, commands[name], name, dir)
+	}
+	path := filepath.Join(dir, "multi-app.yaml")
+	err := os.WriteFile(path, []byte(config.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestConfig(t, dir, map[string]string{
+		"kept":    "sleep 30",
+		"changed": "sleep 30",
+		"removed": "sleep 30",
+		"idle":    "sleep 30",
+	})
+	options := DefaultManagerOptions()
+	options.BasePort, options.MaxPort, options.ManagementPort = 18100, 18199, 18099
+	manager, err := NewManagerFromYaml(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		manager.RunManager.Shutdown(nil, 20*time.Second)
+	})
+	running := make(map[string]*App)
+	for _, name := range []string{"kept", "changed", "removed"} {
+		app, err := manager.GetApp(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = manager.StartApp(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+		running[name] = app
+	}
+	waitFor(t, 5*time.Second, "the apps to launch", func() bool {
+		for _, app := range running {
+			if len(app.supervisedPIDs()) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	keptPIDs := running["kept"].supervisedPIDs()
+
+	writeTestConfig(t, dir, map[string]string{
+		"kept":    "sleep 30",
+		"changed": "sleep 31",
+		"idle":    "sleep 31",
+		"added":   "sleep 30",
+	})
+	// the watcher and the API reloading at once must not start anything twice
+	results := make([]*ReloadResult, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := manager.ReloadFromYaml()
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	result, second := results[0], results[1]
+	if len(result.Restarted) == 0 {
+		result, second = second, result
+	}
+
+	want := ReloadResult{
+		Added:     []string{"added"},
+		Removed:   []string{"removed"},
+		Restarted: []string{"changed"},
+		Updated:   []string{"idle"},
+		Unchanged: []string{"kept"},
+	}
+	if fmt.Sprint(*result) != fmt.Sprint(want) {
+		t.Errorf("first reload = %+v, want %+v", *result, want)
+	}
+	if len(second.Added)+len(second.Removed)+len(second.Restarted)+len(second.Updated) != 0 {
+		t.Errorf("second reload changed apps: %+v", *second)
+	}
+
+	kept, _ := manager.GetApp("kept")
+	if kept != running["kept"] || fmt.Sprint(kept.supervisedPIDs()) != fmt.Sprint(keptPIDs) {
+		t.Errorf("the unchanged app was replaced or restarted, PIDs %v, want %v", kept.supervisedPIDs(), keptPIDs)
+	}
+	changed, _ := manager.GetApp("changed")
+	if changed == running["changed"] {
+		t.Error("the changed app was not replaced")
+	}
+	if status := running["changed"].GetStatus(); status != StatusTerminated {
+		t.Errorf("the old instance of the changed app is %s, want terminated", status)
+	}
+	if status := running["removed"].GetStatus(); status != StatusTerminated {
+		t.Errorf("the removed app is %s, want terminated", status)
+	}
+	if _, err := manager.GetApp("removed"); err == nil {
+		t.Error("the removed app is still configured")
+	}
+
+	var active []string
+	for _, app := range manager.RunManager.ListRunningApps() {
+		active = append(active, app.Name)
+		if app.Name == "changed" && app != changed {
+			t.Error("the old instance of the changed app is still active")
+		}
+	}
+	sort.Strings(active)
+	if fmt.Sprint(active) != "[changed kept]" {
+		t.Errorf("active apps = %v, want [changed kept]", active)
+	}
+}
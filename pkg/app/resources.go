@@ -0,0 +1,250 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// memoryCheckInterval is how often the memory of apps without a cgroup limit is sampled.
+const memoryCheckInterval = 5 * time.Second
+
+// Resources are what an app declares it needs. Starts are only admitted while the
+// declared resources of all running apps fit the host, and the memory is enforced as a
+// limit on the app.
+type Resources struct {
+	MemoryMB int     `yaml:"memoryMB,omitempty" json:"memoryMB,omitempty"`
+	CPUs     float64 `yaml:"cpus,omitempty" json:"cpus,omitempty"`
+}
+
+// HostResources is the capacity of the host, or of the container the relay runs in.
+type HostResources struct {
+	MemoryMB          int     // Memory the relay may use in total
+	AvailableMemoryMB int     // Memory not in use right now
+	CPUs              float64 // CPUs the relay may use
+}
+
+// ReadHostResources reads the capacity from /proc and, where the relay is limited by
+// one, from its cgroup.
+func ReadHostResources() HostResources {
+	host := HostResources{CPUs: float64(runtime.NumCPU())}
+	meminfo := readMeminfo()
+	host.MemoryMB = meminfo["MemTotal"] / 1024
+	host.AvailableMemoryMB = meminfo["MemAvailable"] / 1024
+
+	limit, usage, ok := cgroupMemory()
+	if ok && limit/1024/1024 < int64(host.MemoryMB) {
+		host.MemoryMB = int(limit / 1024 / 1024)
+		if available := int((limit - usage) / 1024 / 1024); available < host.AvailableMemoryMB {
+			host.AvailableMemoryMB = available
+		}
+	}
+	if cpus, ok := cgroupCPUs(); ok && cpus < host.CPUs {
+		host.CPUs = cpus
+	}
+	return host
+}
+
+// readMeminfo returns the fields of /proc/meminfo, in kB.
+func readMeminfo() map[string]int {
+	fields := make(map[string]int)
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return fields
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), " kB"))
+		if err == nil {
+			fields[name] = kb
+		}
+	}
+	return fields
+}
+
+// readCgroupValue reads a single number from a cgroup file. ok is false if the file is
+// missing or holds "max".
+func readCgroupValue(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// cgroupMemory returns the memory limit and usage of the relay's cgroup, in bytes, from
+// cgroup v2 or else v1. ok is false if the relay has no memory limit.
+func cgroupMemory() (limit int64, usage int64, ok bool) {
+	if own, err := ownCgroup(); err == nil {
+		dir := filepath.Join(cgroupMount, own)
+		limit, ok = readCgroupValue(filepath.Join(dir, "memory.max"))
+		usage, _ = readCgroupValue(filepath.Join(dir, "memory.current"))
+		if ok {
+			return limit, usage, true
+		}
+	}
+	dir := filepath.Join(cgroupMount, "memory")
+	limit, ok = readCgroupValue(filepath.Join(dir, "memory.limit_in_bytes"))
+	usage, _ = readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes"))
+	// v1 reports no limit as a huge number
+	if !ok || limit >= 1<<62 {
+		return 0, 0, false
+	}
+	return limit, usage, true
+}
+
+// cgroupCPUs returns the CPU quota of the relay's cgroup, from cgroup v2 or else v1. ok
+// is false if the relay has no quota.
+func cgroupCPUs() (float64, bool) {
+	if own, err := ownCgroup(); err == nil {
+		data, err := os.ReadFile(filepath.Join(cgroupMount, own, "cpu.max"))
+		if err == nil {
+			fields := strings.Fields(string(data))
+			if len(fields) == 2 && fields[0] != "max" {
+				quota, err1 := strconv.ParseFloat(fields[0], 64)
+				period, err2 := strconv.ParseFloat(fields[1], 64)
+				if err1 == nil && err2 == nil && period > 0 {
+					return quota / period, true
+				}
+			}
+			return 0, false
+		}
+	}
+	quota, ok := readCgroupValue(filepath.Join(cgroupMount, "cpu", "cpu.cfs_quota_us"))
+	period, _ := readCgroupValue(filepath.Join(cgroupMount, "cpu", "cpu.cfs_period_us"))
+	if !ok || quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return float64(quota) / float64(period), true
+}
+
+// admit checks whether the declared resources of app fit next to those of the running
+// apps, not counting the apps in excluded, which are about to be evicted.
+func admit(host HostResources, app *App, running []*App, excluded map[*App]bool) error {
+	if app.Resources == nil {
+		return nil
+	}
+	memoryMB, cpus := 0, 0.0
+	freedMB := 0
+	for _, other := range running {
+		if other == app || other.Resources == nil {
+			continue
+		}
+		if excluded[other] {
+			freedMB += other.Resources.MemoryMB
+			continue
+		}
+		memoryMB += other.Resources.MemoryMB
+		cpus += other.Resources.CPUs
+	}
+	if app.Resources.MemoryMB > 0 {
+		if memoryMB+app.Resources.MemoryMB > host.MemoryMB {
+			return fmt.Errorf("not enough memory: app needs %d MB, %d of %d MB are reserved by running apps",
+				app.Resources.MemoryMB, memoryMB, host.MemoryMB)
+		}
+		if app.Resources.MemoryMB > host.AvailableMemoryMB+freedMB {
+			return fmt.Errorf("not enough memory: app needs %d MB, %d MB are available",
+				app.Resources.MemoryMB, host.AvailableMemoryMB+freedMB)
+		}
+	}
+	if app.Resources.CPUs > 0 && cpus+app.Resources.CPUs > host.CPUs {
+		return fmt.Errorf("not enough CPUs: app needs %g, %g of %g are reserved by running apps",
+			app.Resources.CPUs, cpus, host.CPUs)
+	}
+	return nil
+}
+
+// fitsHost reports why app could never start on the host, even with no other app
+// running, so it is not worth queueing.
+func fitsHost(host HostResources, app *App) error {
+	host.AvailableMemoryMB = host.MemoryMB
+	return admit(host, app, nil, nil)
+}
+
+// memoryLimitMB returns the memory limit of the app, zero if it has none.
+func (a *App) memoryLimitMB() int {
+	if a.Resources == nil {
+		return 0
+	}
+	return a.Resources.MemoryMB
+}
+
+// processMemoryMB returns the memory of the processes, in MB. It adds up their
+// proportional set size, which splits the pages that pre-forked workers share with each
+// other, so shared pages are only counted once.
+func processMemoryMB(pids map[int]bool) int {
+	var totalKB int64
+	for pid := range pids {
+		totalKB += processPssKB(pid)
+	}
+	return int(totalKB / 1024)
+}
+
+// processPssKB returns the proportional set size of the process from
+// /proc/<pid>/smaps_rollup, in kB, zero if it is gone.
+func processPssKB(pid int) int64 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/smaps_rollup", pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, "Pss:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err == nil {
+			return kb
+		}
+	}
+	return 0
+}
+
+// watchMemory samples the memory of the app's processes until done is closed, and kills
+// the app if it goes over its limit. Where the limit is set on the app's cgroup, the
+// kernel enforces it instead.
+func (a *App) watchMemory(done <-chan struct{}) {
+	limit := a.memoryLimitMB()
+	a.mutex.Lock()
+	if a.cgroupLimited {
+		limit = 0
+	}
+	a.mutex.Unlock()
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		roots := a.supervisedPIDs()
+		usage := processMemoryMB(a.processTree(roots))
+		a.mutex.Lock()
+		a.memoryUsageMB = usage
+		a.mutex.Unlock()
+		if limit == 0 || usage <= limit {
+			continue
+		}
+		fmt.Println("App", a.Name, "uses", usage, "MB, over its limit of", limit, "MB, killing it")
+		a.mutex.Lock()
+		a.overMemoryLimit = true
+		a.mutex.Unlock()
+		for root := range roots {
+			syscall.Kill(-root, syscall.SIGKILL)
+		}
+		return
+	}
+}
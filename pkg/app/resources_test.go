@@ -0,0 +1,92 @@
+package app
+
+import (
+	"os"
+	"testing"
+)
+
+// appWith returns an app that declares the given resources, none if memoryMB and cpus
+// are both zero.
+func appWith(name string, memoryMB int, cpus float64) *App {
+	app := &App{ID: name, Name: name}
+	if memoryMB != 0 || cpus != 0 {
+		app.Resources = &Resources{MemoryMB: memoryMB, CPUs: cpus}
+	}
+	return app
+}
+
+func TestAdmit(t *testing.T) {
+	host := HostResources{MemoryMB: 4000, AvailableMemoryMB: 1500, CPUs: 4}
+	big := appWith("big", 2000, 2)
+	small := appWith("small", 500, 1)
+	undeclared := appWith("undeclared", 0, 0)
+
+	tests := []struct {
+		name     string
+		app      *App
+		running  []*App
+		excluded []*App
+		wantErr  bool
+	}{
+		{"no resources declared", appWith("new", 0, 0), []*App{big, small}, nil, false},
+		{"fits", appWith("new", 1000, 1), []*App{big, small}, nil, false},
+		{"not enough available memory", appWith("new", 1600, 0), nil, nil, true},
+		{"not enough memory left to reserve", appWith("new", 1200, 0), []*App{big, small, appWith("other", 1000, 0)}, nil, true},
+		{"too many cpus", appWith("new", 0, 1.5), []*App{big, small}, nil, true},
+		{"undeclared apps reserve nothing", appWith("new", 1000, 1), []*App{big, undeclared}, nil, false},
+		{"itself does not count", small, []*App{big, small}, nil, false},
+		{"reserved by running apps", appWith("new", 2500, 0), []*App{big, small}, nil, true},
+		{"excluded apps free their reservation", appWith("new", 2500, 0), []*App{big, small}, []*App{big}, false},
+		{"excluded apps free their memory", appWith("new", 3000, 2), []*App{big, small}, []*App{big, small}, false},
+		{"excluded apps free their cpus", appWith("new", 0, 3), []*App{big, small}, []*App{big}, false},
+		{"excluded undeclared apps free nothing", appWith("new", 1600, 0), []*App{undeclared}, []*App{undeclared}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			excluded := make(map[*App]bool)
+			for _, app := range tt.excluded {
+				excluded[app] = true
+			}
+			err := admit(host, tt.app, tt.running, excluded)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("admit() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFitsHost(t *testing.T) {
+	// other processes using memory right now must not make an app unstartable for good
+	host := HostResources{MemoryMB: 4000, AvailableMemoryMB: 100, CPUs: 2}
+	tests := []struct {
+		name    string
+		app     *App
+		wantErr bool
+	}{
+		{"no resources declared", appWith("app", 0, 0), false},
+		{"more than available now", appWith("app", 3000, 1), false},
+		{"all of the host", appWith("app", 4000, 2), false},
+		{"more memory than the host has", appWith("app", 4001, 0), true},
+		{"more cpus than the host has", appWith("app", 0, 2.5), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fitsHost(host, tt.app)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fitsHost() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessMemoryMB(t *testing.T) {
+	if _, err := os.Stat("/proc/self/smaps_rollup"); err != nil {
+		t.Skip("no smaps_rollup on this kernel")
+	}
+	if processPssKB(os.Getpid()) <= 0 {
+		t.Error("the test process has no proportional set size")
+	}
+	if processMemoryMB(map[int]bool{-1: true}) != 0 {
+		t.Error("a missing process uses memory")
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"time"
+)
+
+// RestartPolicyType represents when a stopped app is started again.
+type RestartPolicyType string
+
+const (
+	RestartNever     RestartPolicyType = "never"
+	RestartOnFailure RestartPolicyType = "on-failure"
+	RestartAlways    RestartPolicyType = "always"
+)
+
+// IsValid checks if a given restart policy is valid.
+func (p RestartPolicyType) IsValid() bool {
+	switch p {
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return true
+	}
+	return false
+}
+
+// String returns the string representation of the restart policy.
+func (p RestartPolicyType) String() string {
+	return string(p)
+}
+
+const (
+	defaultRestartBackoff    = 1 * time.Second
+	defaultRestartMaxBackoff = 1 * time.Minute
+	// restartResetPeriod is how long an app has to stay ready for its restarts to be
+	// forgotten, so maxRetries only counts crashes that follow each other closely.
+	restartResetPeriod = 10 * time.Minute
+)
+
+// RestartPolicy configures how the RunManager reacts when an app process exits on its
+// own. A MaxRetries of 0 retries forever.
+type RestartPolicy struct {
+	Policy     RestartPolicyType `yaml:"policy" json:"policy"`
+	MaxRetries int               `yaml:"maxRetries,omitempty" json:"maxRetries,omitempty"`
+	Backoff    Duration          `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	MaxBackoff Duration          `yaml:"maxBackoff,omitempty" json:"maxBackoff,omitempty"`
+}
+
+// shouldRestart decides whether an app that stopped on its own after restarts previous
+// restarts gets started again. failed is set when setup or the process failed.
+func (p *RestartPolicy) shouldRestart(failed bool, restarts int) bool {
+	if p == nil {
+		return false
+	}
+	if p.MaxRetries > 0 && restarts >= p.MaxRetries {
+		return false
+	}
+	switch p.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	}
+	return false
+}
+
+// ranStable reports whether a run that became ready at readyAt and stopped at stoppedAt
+// was healthy for long enough to reset the restart count. readyAt is zero if the run
+// never became ready.
+func ranStable(readyAt, stoppedAt time.Time) bool {
+	return !readyAt.IsZero() && stoppedAt.Sub(readyAt) >= restartResetPeriod
+}
+
+// delay returns the exponential backoff before the given restart attempt.
+func (p *RestartPolicy) delay(restarts int) time.Duration {
+	backoff := p.Backoff.OrDefault(defaultRestartBackoff)
+	maxBackoff := p.MaxBackoff.OrDefault(defaultRestartMaxBackoff)
+	for i := 0; i < restarts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// testPorts hands out distinct ports to the apps of the tests.
+var testPorts = NewPortAllocator(18000, 18999)
+
+// newTestApp returns a command app that runs the shell command on a free port.
+func newTestApp(t *testing.T, name string, command string) *App {
+	t.Helper()
+	port, err := testPorts.Allocate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(name, t.TempDir(), name, TypeCommand, nil, port)
+	app.ShellCommand = command
+	return app
+}
+
+// waitFor polls cond until it holds, failing the test after the timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRestartRelaunchesApp(t *testing.T) {
+	app := newTestApp(t, "crashing", "exit 3")
+	app.RestartPolicy = &RestartPolicy{
+		Policy:     RestartOnFailure,
+		MaxRetries: 2,
+		Backoff:    Duration(10 * time.Millisecond),
+	}
+	manager := NewAppRunManager(0)
+	err := manager.RunApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, "the restarts to run out", func() bool {
+		_, err := manager.GetRunningApp(app.ID)
+		return err != nil
+	})
+
+	info := app.Info()
+	if info.RestartCount != 2 {
+		t.Errorf("RestartCount = %d, want 2", info.RestartCount)
+	}
+	if info.Status != StatusFailed || info.LastExitCode != 3 || info.LastError != "exited with code 3" {
+		t.Errorf("got status %s, exit code %d, error %q, want failed, 3, \"exited with code 3\"",
+			info.Status, info.LastExitCode, info.LastError)
+	}
+}
+
+func TestFailedRestartFreesSlot(t *testing.T) {
+	app := newTestApp(t, "restart-port-taken", "sleep 0.2; exit 1")
+	app.RestartPolicy = &RestartPolicy{Policy: RestartAlways, Backoff: Duration(500 * time.Millisecond)}
+	manager := NewAppRunManager(0)
+	err := manager.RunApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the app to exit", func() bool {
+		return app.Info().StoppedAt != nil
+	})
+	// the restart finds its port taken
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", app.PreferredPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	waitFor(t, 5*time.Second, "the app to give up its slot", func() bool {
+		_, err := manager.GetRunningApp(app.ID)
+		return err != nil
+	})
+	if info := app.Info(); info.RestartCount != 1 {
+		t.Errorf("RestartCount = %d, want 1", info.RestartCount)
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *RestartPolicy
+		failed   bool
+		restarts int
+		want     bool
+	}{
+		{"no policy", nil, true, 0, false},
+		{"never", &RestartPolicy{Policy: RestartNever}, true, 0, false},
+		{"always after a clean exit", &RestartPolicy{Policy: RestartAlways}, false, 0, true},
+		{"always after a failure", &RestartPolicy{Policy: RestartAlways}, true, 5, true},
+		{"on-failure after a failure", &RestartPolicy{Policy: RestartOnFailure}, true, 0, true},
+		{"on-failure after a clean exit", &RestartPolicy{Policy: RestartOnFailure}, false, 0, false},
+		{"below maxRetries", &RestartPolicy{Policy: RestartOnFailure, MaxRetries: 3}, true, 2, true},
+		{"at maxRetries", &RestartPolicy{Policy: RestartOnFailure, MaxRetries: 3}, true, 3, false},
+		{"unlimited retries", &RestartPolicy{Policy: RestartOnFailure}, true, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.shouldRestart(tt.failed, tt.restarts)
+			if got != tt.want {
+				t.Errorf("shouldRestart(%v, %d) = %v, want %v", tt.failed, tt.restarts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *RestartPolicy
+		restarts int
+		want     time.Duration
+	}{
+		{"default first", &RestartPolicy{}, 0, defaultRestartBackoff},
+		{"default doubles", &RestartPolicy{}, 2, 4 * defaultRestartBackoff},
+		{"default capped", &RestartPolicy{}, 20, defaultRestartMaxBackoff},
+		{"configured first", &RestartPolicy{Backoff: Duration(2 * time.Second)}, 0, 2 * time.Second},
+		{"configured doubles", &RestartPolicy{Backoff: Duration(2 * time.Second)}, 3, 16 * time.Second},
+		{"configured cap", &RestartPolicy{Backoff: Duration(2 * time.Second), MaxBackoff: Duration(5 * time.Second)}, 2, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.delay(tt.restarts)
+			if got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.restarts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRanStable(t *testing.T) {
+	stoppedAt := time.Now()
+	tests := []struct {
+		name    string
+		readyAt time.Time
+		want    bool
+	}{
+		{"never ready", time.Time{}, false},
+		{"ready briefly", stoppedAt.Add(-time.Minute), false},
+		{"ready for the reset period", stoppedAt.Add(-restartResetPeriod), true},
+		{"ready for days", stoppedAt.Add(-72 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ranStable(tt.readyAt, stoppedAt)
+			if got != tt.want {
+				t.Errorf("ranStable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CommandSpec is the command of an app. It is written either as a shell string, which
+// runs through /bin/bash -c, or as a YAML list (exec form), which runs without a shell.
+type CommandSpec struct {
+	Shell string
+	Exec  []string
+}
+
+// UnmarshalYAML accepts a string (shell form) or a list of strings (exec form).
+func (c *CommandSpec) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		c.Exec = nil
+		return value.Decode(&c.Shell)
+	case yaml.SequenceNode:
+		c.Shell = ""
+		return value.Decode(&c.Exec)
+	}
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: command must be a string or a list of strings", value.Line)}}
+}
+
+// MarshalJSON renders the command in the form it was written in.
+func (c CommandSpec) MarshalJSON() ([]byte, error) {
+	if c.IsExec() {
+		return json.Marshal(c.Exec)
+	}
+	return json.Marshal(c.Shell)
+}
+
+// IsExec reports whether the command was given in exec form.
+func (c CommandSpec) IsExec() bool {
+	return c.Exec != nil
+}
+
+// IsEmpty reports whether no command was given.
+func (c CommandSpec) IsEmpty() bool {
+	return strings.TrimSpace(c.Shell) == "" && len(c.Exec) == 0
+}
+
+// Map returns a copy of the command with mapping applied to the shell string or to every
+// exec argument.
+func (c CommandSpec) Map(mapping func(string) string) CommandSpec {
+	if !c.IsExec() {
+		return CommandSpec{Shell: mapping(c.Shell)}
+	}
+	exec := make([]string, 0, len(c.Exec))
+	for _, arg := range c.Exec {
+		exec = append(exec, mapping(arg))
+	}
+	return CommandSpec{Exec: exec}
+}
+
+// splitShellWords splits a command line into words following POSIX shell quoting rules:
+// single quotes are literal, double quotes honour backslash escapes of $ ` " \ and
+// newline, and a backslash outside quotes escapes the next character.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			inWord = true
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+				}
+			}
+		case r == '\'':
+			inWord = true
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated single quote in %q", line)
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+		case r == '"':
+			inWord = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated double quote in %q", line)
+			}
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quoteShellWord quotes a word so the shell passes it through as a single argument.
+func quoteShellWord(word string) string {
+	if word != "" && strings.IndexFunc(word, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,@%+", r))
+	}) == -1 {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'"'"'`) + "'"
+}
+
+// lookPathIn resolves an executable name against the PATH of the given environment,
+// rather than the relay's own. Names containing a slash are returned unchanged.
+func lookPathIn(name string, env []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	path := os.Getenv("PATH")
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = value
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, name)
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("executable %s not found in PATH", name)
+}
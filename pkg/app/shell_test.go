@@ -0,0 +1,58 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "  \t\n ", want: nil},
+		{line: "streamlit run app.py", want: []string{"streamlit", "run", "app.py"}},
+		{line: "  spaced   out\targs\n", want: []string{"spaced", "out", "args"}},
+		{line: `run --title "My App"`, want: []string{"run", "--title", "My App"}},
+		{line: `run --title 'My App'`, want: []string{"run", "--title", "My App"}},
+		{line: `echo 'a "b" \n $c'`, want: []string{"echo", `a "b" \n $c`}},
+		{line: `echo "a \"b\" \$c \\ \n"`, want: []string{"echo", `a "b" $c \ \n`}},
+		{line: `echo My\ App \"x\"`, want: []string{"echo", "My App", `"x"`}},
+		{line: "echo a\\\nb", want: []string{"echo", "ab"}},
+		{line: "echo \"a\\\nb\"", want: []string{"echo", "ab"}},
+		{line: `FOO=bar env`, want: []string{"FOO=bar", "env"}},
+		{line: `--opt="a b"c'd e'`, want: []string{"--opt=a bcd e"}},
+		{line: `echo "" ''`, want: []string{"echo", "", ""}},
+		{line: `echo ünï "cödé"`, want: []string{"echo", "ünï", "cödé"}},
+		{line: `echo 'open`, wantErr: true},
+		{line: `echo "open`, wantErr: true},
+		{line: `echo "open\"`, wantErr: true},
+	}
+	for _, test := range tests {
+		words, err := splitShellWords(test.line)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("splitShellWords(%q) = %q, want an error", test.line, words)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitShellWords(%q): %v", test.line, err)
+			continue
+		}
+		if fmt.Sprintf("%q", words) != fmt.Sprintf("%q", test.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", test.line, words, test.want)
+		}
+	}
+}
+
+func TestQuoteShellWord(t *testing.T) {
+	for _, word := range []string{"plain", "", "My App", "it's", `"$HOME"`, "a\\b", "x;y", "--port=8000"} {
+		words, err := splitShellWords("run " + quoteShellWord(word))
+		if err != nil || len(words) != 2 || words[1] != word {
+			t.Errorf("quoteShellWord(%q) = %s, splits into %q, %v", word, quoteShellWord(word), words, err)
+		}
+	}
+}
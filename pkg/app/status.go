@@ -4,16 +4,21 @@ package app
 type Status string
 
 const (
+	StatusQueued     Status = "queued" // waiting in the start queue for room
 	StatusSetup      Status = "setup"
 	StatusStarting   Status = "starting"
-	StatusRunning    Status = "running"
+	StatusRunning    Status = "running" // process launched, waiting for its health check to pass
+	StatusReady      Status = "ready"
+	StatusUnhealthy  Status = "unhealthy"
+	StatusStopping   Status = "stopping" // stop requested, waiting for the app's processes and port to go
 	StatusTerminated Status = "terminated"
+	StatusFailed     Status = "failed" // setup failed or the process exited abnormally
 )
 
 // IsValid checks if a given status is valid.
 func (s Status) IsValid() bool {
 	switch s {
-	case StatusStarting, StatusSetup, StatusRunning, StatusTerminated:
+	case StatusQueued, StatusStarting, StatusSetup, StatusRunning, StatusReady, StatusUnhealthy, StatusStopping, StatusTerminated, StatusFailed:
 		return true
 	}
 	return false
@@ -23,3 +28,8 @@ func (s Status) IsValid() bool {
 func (s Status) String() string {
 	return string(s)
 }
+
+// IsStopped reports whether an app in this status has no process and can be started.
+func (s Status) IsStopped() bool {
+	return s == StatusTerminated || s == StatusFailed
+}
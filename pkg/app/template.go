@@ -0,0 +1,155 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RelayPathPrefix is the path prefix apps are proxied under, see ROOT_PATH.
+const RelayPathPrefix = "/relay"
+
+var templateVariable = regexp.MustCompile(`\$\{(env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// TemplateContext holds the values that can be referenced from an app's config:
+//
+//	${PORT}        the port the app has to listen on
+//	${APP_NAME}    the name of the app
+//	${ROUTE_PATH}  the routePath of the app
+//	${ROOT_PATH}   the path the app is proxied under, e.g. /relay/<name>
+//	${CODE_PATH}   the absolute directory of the app's code
+//	${env:VAR}     the variable VAR of the relay's environment
+//
+// Any other ${...} reference is left as is, so it can still be expanded by the shell. In
+// shell form commands the values are quoted, see ExpandShell.
+type TemplateContext struct {
+	Port      int
+	AppName   string
+	RoutePath string
+	RootPath  string
+	CodePath  string
+}
+
+// NewTemplateContext returns the template context of an app listening on port.
+func NewTemplateContext(c *Config, rootDir string, port int) *TemplateContext {
+	routePath := ""
+	if c.RoutePath != nil {
+		routePath = *c.RoutePath
+	}
+	return &TemplateContext{
+		Port:      port,
+		AppName:   c.Name,
+		RoutePath: routePath,
+		RootPath:  RelayPathPrefix + "/" + c.Name,
+		CodePath:  rootDir,
+	}
+}
+
+// Expand replaces the template variables in s.
+func (t *TemplateContext) Expand(s string) string {
+	return templateVariable.ReplaceAllStringFunc(s, func(match string) string {
+		groups := templateVariable.FindStringSubmatch(match)
+		if groups[1] != "" {
+			return os.Getenv(groups[2])
+		}
+		switch groups[2] {
+		case "PORT":
+			return fmt.Sprintf("%d", t.Port)
+		case "APP_NAME":
+			return t.AppName
+		case "ROUTE_PATH":
+			return t.RoutePath
+		case "ROOT_PATH":
+			return t.RootPath
+		case "CODE_PATH":
+			return t.CodePath
+		}
+		return match
+	})
+}
+
+// ExpandShell replaces the template variables in a shell command line. Each value is
+// quoted for the quotes it appears in, so the shell takes it literally: spaces do not
+// split it into words and $, ` or ; in an environment variable are not run.
+func (t *TemplateContext) ExpandShell(line string) string {
+	var expanded strings.Builder
+	quote := rune(0) // the quote the scan is in, if any
+	scanned, last := 0, 0
+	for _, match := range templateVariable.FindAllStringIndex(line, -1) {
+		for ; scanned < match[0]; scanned++ {
+			c := rune(line[scanned])
+			switch {
+			case c == '\\' && quote != '\'':
+				scanned++
+			case quote == 0 && (c == '\'' || c == '"'):
+				quote = c
+			case c == quote:
+				quote = 0
+			}
+		}
+		if scanned > match[0] {
+			// the shell takes an escaped variable literally, so do we
+			continue
+		}
+		value := t.Expand(line[match[0]:match[1]])
+		expanded.WriteString(line[last:match[0]])
+		switch quote {
+		case '\'':
+			expanded.WriteString(strings.ReplaceAll(value, "'", `'"'"'`))
+		case '"':
+			expanded.WriteString(doubleQuoteEscaper.Replace(value))
+		default:
+			expanded.WriteString(quoteShellWord(value))
+		}
+		last = match[1]
+	}
+	expanded.WriteString(line[last:])
+	return expanded.String()
+}
+
+// doubleQuoteEscaper escapes the characters that keep their meaning inside double quotes.
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, "`", "\\`", `"`, `\"`)
+
+// ExpandAll replaces the template variables in every element of values.
+func (t *TemplateContext) ExpandAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	expanded := make([]string, 0, len(values))
+	for _, value := range values {
+		expanded = append(expanded, t.Expand(value))
+	}
+	return expanded
+}
+
+// Render returns a copy of the config with the template variables expanded in its
+// command, args, environment and health check. The config itself is left untouched.
+func (t *TemplateContext) Render(c *Config) *Config {
+	rendered := *c
+	if c.Command.IsExec() {
+		rendered.Command = c.Command.Map(t.Expand)
+	} else {
+		rendered.Command = c.Command.Map(t.ExpandShell)
+	}
+	rendered.Args = t.ExpandAll(c.Args)
+	rendered.Env = make([]EnvVar, 0, len(c.Env))
+	for _, envVar := range c.Env {
+		envVar.Value = t.Expand(envVar.Value)
+		rendered.Env = append(rendered.Env, envVar)
+	}
+	rendered.EnvFrom = make([]EnvSource, 0, len(c.EnvFrom))
+	for _, source := range c.EnvFrom {
+		source.File = t.Expand(source.File)
+		rendered.EnvFrom = append(rendered.EnvFrom, source)
+	}
+	if c.HealthCheck != nil {
+		healthCheck := *c.HealthCheck
+		healthCheck.Path = t.Expand(healthCheck.Path)
+		if healthCheck.Path != "" && !strings.HasPrefix(healthCheck.Path, "/") {
+			healthCheck.Path = "/" + healthCheck.Path
+		}
+		rendered.HealthCheck = &healthCheck
+	}
+	return &rendered
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExpandShell(t *testing.T) {
+	t.Setenv("RELAY_TEST_VALUE", `a b; echo "$HOME" 'x' \`)
+	context := &TemplateContext{Port: 8000, AppName: "app", CodePath: "/srv/my apps/app"}
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"plain", "serve --port ${PORT} ${APP_NAME}", []string{"serve", "--port", "8000", "app"}},
+		{"unquoted", "echo ${env:RELAY_TEST_VALUE}", []string{"echo", `a b; echo "$HOME" 'x' \`}},
+		{"double quoted", `echo "--value=${env:RELAY_TEST_VALUE}!"`, []string{"echo", `--value=a b; echo "$HOME" 'x' \!`}},
+		{"single quoted", "echo '${env:RELAY_TEST_VALUE}'", []string{"echo", `a b; echo "$HOME" 'x' \`}},
+		{"spaces in path", "cd ${CODE_PATH} && run", []string{"cd", "/srv/my apps/app", "&&", "run"}},
+		{"unset", "run ${env:RELAY_TEST_UNSET} last", []string{"run", "", "last"}},
+		{"escaped", `echo \${PORT} ${PORT}`, []string{"echo", "${PORT}", "8000"}},
+		{"shell variable", "echo ${HOME}", []string{"echo", "${HOME}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expanded := context.ExpandShell(test.line)
+			words, err := splitShellWords(expanded)
+			if err != nil {
+				t.Fatalf("%s: %v", expanded, err)
+			}
+			if fmt.Sprintf("%q", words) != fmt.Sprintf("%q", test.want) {
+				t.Errorf("%s splits into %q, want %q", expanded, words, test.want)
+			}
+		})
+	}
+}
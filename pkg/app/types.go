@@ -1,18 +1,26 @@
 package app
 
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"time"
+)
+
 // Type represents the state of an app.
 type Type string
 
 const (
-	TypePython Type = "python"
-	TypeR      Type = "r"
-	TypeNodejs Type = "nodejs"
+	TypePython  Type = "python"
+	TypeR       Type = "r"
+	TypeNodejs  Type = "nodejs"
+	TypeCommand Type = "command"
 )
 
 // IsValid checks if a given status is valid.
 func (s Type) IsValid() bool {
 	switch s {
-	case TypeNodejs, TypeR, TypePython:
+	case TypeNodejs, TypeR, TypePython, TypeCommand:
 		return true
 	}
 	return false
@@ -22,3 +30,39 @@ func (s Type) IsValid() bool {
 func (s Type) String() string {
 	return string(s)
 }
+
+// Duration is a time.Duration that reads from YAML as a Go duration string, e.g. "5s".
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string such as "500ms" or "2m".
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var raw string
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		// a TypeError lets the decoder report it and carry on with the rest of the config
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: invalid duration %q", value.Line, raw)}}
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON renders the duration the same way it is written in the YAML config.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// String returns the string representation of the duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// OrDefault returns the duration, or def when it is unset.
+func (d Duration) OrDefault(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
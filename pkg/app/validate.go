@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	appNamePattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	envNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	yamlErrorPattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+)
+
+// ValidationError is a single problem found in the config, with the YAML line it is on.
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Detail())
+}
+
+// Detail returns the message prefixed with the path of the offending key, if known.
+func (e ValidationError) Detail() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors is every problem found in the config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// ParseConfig strictly decodes and validates a YAML config. Unknown keys are errors. If the
+// config has problems, the returned error is a ValidationErrors holding all of them.
+func ParseConfig(data []byte) (*AppsConfig, error) {
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{lines: make(map[string]int)}
+	v.collectLines(&root, "")
+
+	var config AppsConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	var typeErr *yaml.TypeError
+	switch {
+	case errors.As(err, &typeErr):
+		// decoding carries on past type errors, so the rest of the config can still be checked
+		for _, message := range typeErr.Errors {
+			v.addYamlError(message)
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	v.validate(&config)
+	if len(v.errors) > 0 {
+		return nil, v.errors
+	}
+	return &config, nil
+}
+
+type validator struct {
+	lines  map[string]int
+	errors ValidationErrors
+}
+
+// collectLines records the line of every key and list item under their path, e.g.
+// apps[1].healthCheck.type.
+func (v *validator) collectLines(node *yaml.Node, path string) {
+	if _, exists := v.lines[path]; !exists {
+		v.lines[path] = node.Line
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			v.collectLines(child, path)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := node.Content[i].Value
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			v.lines[childPath] = node.Content[i].Line
+			v.collectLines(node.Content[i+1], childPath)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			v.collectLines(child, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// line returns the line of path, or of its closest parent that is in the config.
+func (v *validator) line(path string) int {
+	for {
+		if line, exists := v.lines[path]; exists {
+			return line
+		}
+		cut := strings.LastIndexAny(path, ".[")
+		if cut <= 0 {
+			return v.lines[""]
+		}
+		path = path[:cut]
+	}
+}
+
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{
+		Line:    v.line(path),
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) addYamlError(message string) {
+	groups := yamlErrorPattern.FindStringSubmatch(message)
+	if groups == nil {
+		v.errors = append(v.errors, ValidationError{Message: message})
+		return
+	}
+	line, _ := strconv.Atoi(groups[1])
+	v.errors = append(v.errors, ValidationError{Line: line, Message: groups[2]})
+}
+
+func (v *validator) validate(config *AppsConfig) {
+	names := make(map[string]string)
+	routes := make(map[string]string)
+	ports := make(map[int]string)
+	if config.ManagementUi == nil {
+		v.add("ui", "management ui config is required")
+	} else {
+		v.validateApp(config.ManagementUi, "ui", names, nil)
+		if config.ManagementUi.Port != 0 {
+			v.add("ui.port", "the management ui runs on the management port, set it with -management-port")
+		}
+		if config.ManagementUi.IdleTimeout != 0 {
+			v.add("ui.idleTimeout", "the management ui is never stopped for being idle")
+		}
+		if config.ManagementUi.AutoStart {
+			v.add("ui.autoStart", "the management ui always runs, it is not started on demand")
+		}
+	}
+	if len(config.Apps) == 0 {
+		v.add("apps", "no apps found in config")
+	}
+	for i, appConfig := range config.Apps {
+		path := fmt.Sprintf("apps[%d]", i)
+		v.validateApp(appConfig, path, names, routes)
+		v.validatePort(appConfig.Port, path, ports)
+	}
+}
+
+// validatePort checks a pinned port. ports maps the ports seen so far to the path of the
+// app that pinned them.
+func (v *validator) validatePort(port int, path string, ports map[int]string) {
+	switch {
+	case port == 0:
+	case port < 1 || port > 65535:
+		v.add(path+".port", "invalid port %d", port)
+	case ports[port] != "":
+		v.add(path+".port", "duplicate port %d, already used by %s", port, ports[port])
+	default:
+		ports[port] = path
+	}
+}
+
+// validateApp checks a single app. names and routes map the names and normalized route
+// paths seen so far to the path of the app that declared them; routes is nil for the
+// management ui, which is not relayed.
+func (v *validator) validateApp(c *Config, path string, names map[string]string, routes map[string]string) {
+	if c == nil {
+		v.add(path, "app config is empty")
+		return
+	}
+	switch {
+	case c.Name == "":
+		v.add(path+".name", "name is required")
+	case !appNamePattern.MatchString(c.Name):
+		v.add(path+".name", "name %q may only contain letters, digits, '.', '_' and '-'", c.Name)
+	case names[c.Name] != "":
+		v.add(path+".name", "duplicate name %q, already used by %s", c.Name, names[c.Name])
+	default:
+		names[c.Name] = path
+	}
+
+	if c.Command.IsEmpty() {
+		v.add(path+".command", "command is required")
+	} else if !c.Command.IsExec() {
+		if _, err := splitShellWords(c.Command.Shell); err != nil {
+			v.add(path+".command", "%v", err)
+		}
+	}
+
+	switch {
+	case c.Type == "":
+		v.add(path+".type", "type is required")
+	case !c.Type.IsValid():
+		v.add(path+".type", "invalid type %q, expected one of python, nodejs, r, command", c.Type)
+	}
+
+	if c.CodePath == nil || *c.CodePath == "" {
+		v.add(path+".codePath", "codePath is required")
+	}
+	if c.RoutePath == nil || *c.RoutePath == "" {
+		v.add(path+".routePath", "routePath is required")
+	} else if routes != nil {
+		v.validateRoute(*c.RoutePath, path, routes)
+	}
+
+	if c.IdleTimeout < 0 {
+		v.add(path+".idleTimeout", "idleTimeout must not be negative")
+	}
+	if c.Resources != nil {
+		if c.Resources.MemoryMB < 0 {
+			v.add(path+".resources.memoryMB", "memoryMB must not be negative")
+		}
+		if c.Resources.CPUs < 0 {
+			v.add(path+".resources.cpus", "cpus must not be negative")
+		}
+	}
+	v.validateHealthCheck(c.HealthCheck, path+".healthCheck")
+	v.validateRestartPolicy(c.RestartPolicy, path+".restartPolicy")
+	v.validateEnv(c, path)
+
+	if c.Python != nil {
+		if c.Type != TypePython {
+			v.add(path+".python", "python settings only apply to python apps")
+		}
+		if c.Python.DependencyManager != "" && !c.Python.DependencyManager.IsValid() {
+			v.add(path+".python.dependencyManager", "invalid dependencyManager %q, expected one of pip, uv, poetry, pipenv", c.Python.DependencyManager)
+		}
+	}
+}
+
+// validateRoute rejects route paths that are used twice or nested in one another, since
+// the relay could not tell the apps apart.
+func (v *validator) validateRoute(routePath string, path string, routes map[string]string) {
+	route := "/" + strings.Trim(routePath, "/")
+	for other, otherPath := range routes {
+		switch {
+		case other == route:
+			v.add(path+".routePath", "duplicate routePath %q, already used by %s", routePath, otherPath)
+			return
+		case strings.HasPrefix(route, other+"/") || strings.HasPrefix(other, route+"/"):
+			v.add(path+".routePath", "routePath %q conflicts with %q of %s", routePath, other, otherPath)
+			return
+		}
+	}
+	routes[route] = path
+}
+
+func (v *validator) validateHealthCheck(h *HealthCheck, path string) {
+	if h == nil {
+		return
+	}
+	if !h.Type.IsValid() {
+		v.add(path+".type", "invalid healthCheck type %q, expected http or tcp", h.Type)
+	}
+	if h.Type == HealthCheckTCP && h.Path != "" {
+		v.add(path+".path", "path only applies to http health checks")
+	}
+	if h.ExpectedStatus != 0 && (h.ExpectedStatus < 100 || h.ExpectedStatus > 599) {
+		v.add(path+".expectedStatus", "invalid HTTP status %d", h.ExpectedStatus)
+	}
+	if h.FailureThreshold < 0 {
+		v.add(path+".failureThreshold", "failureThreshold must not be negative")
+	}
+}
+
+func (v *validator) validateRestartPolicy(p *RestartPolicy, path string) {
+	if p == nil {
+		return
+	}
+	if !p.Policy.IsValid() {
+		v.add(path+".policy", "invalid restartPolicy %q, expected one of never, on-failure, always", p.Policy)
+	}
+	if p.MaxRetries < 0 {
+		v.add(path+".maxRetries", "maxRetries must not be negative")
+	}
+}
+
+func (v *validator) validateEnv(c *Config, path string) {
+	for i, envVar := range c.Env {
+		if !envNamePattern.MatchString(envVar.Name) {
+			v.add(fmt.Sprintf("%s.env[%d].name", path, i), "invalid environment variable name %q", envVar.Name)
+		}
+	}
+	for i, source := range c.EnvFrom {
+		if source.File == "" && len(source.Parent) == 0 {
+			v.add(fmt.Sprintf("%s.envFrom[%d]", path, i), "envFrom needs a file or a parent list")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// validTestConfig is a valid config whose first app ends on line 13, so cases can append
+// to it.
+const validTestConfig = `version: 1
+ui:
+  name: ui
+  command: sleep 30
+  routePath: /
+  codePath: ui
+  type: command
+apps:
+  - name: app1
+    command: streamlit run app.py
+    routePath: /app1
+    codePath: app1
+    type: python
+`
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "valid",
+			config: validTestConfig,
+		},
+		{
+			name:   "unknown app field",
+			config: validTestConfig + "    colour: blue\n",
+			want:   []string{"line 14: field colour not found in type app.Config"},
+		},
+		{
+			name:   "unknown top level field",
+			config: "timeout: 10\n" + validTestConfig,
+			want:   []string{"line 1: field timeout not found in type app.AppsConfig"},
+		},
+		{
+			name:   "wrong value type",
+			config: validTestConfig + "    port: eighty\n",
+			want:   []string{"line 14: cannot unmarshal !!str `eighty` into int"},
+		},
+		{
+			name:   "invalid type",
+			config: strings.Replace(validTestConfig, "type: python", "type: ruby", 1),
+			want:   []string{`line 13: apps[0].type: invalid type "ruby", expected one of python, nodejs, r, command`},
+		},
+		{
+			name:   "missing command",
+			config: strings.Replace(validTestConfig, "    command: streamlit run app.py\n", "", 1),
+			want:   []string{"line 9: apps[0].command: command is required"},
+		},
+		{
+			name:   "unterminated quote",
+			config: strings.Replace(validTestConfig, "app.py", `"app.py`, 1),
+			want:   []string{`line 10: apps[0].command: unterminated double quote in "streamlit run \"app.py"`},
+		},
+		{
+			name: "duplicate name and nested route",
+			config: validTestConfig +
+				"  - name: app1\n    command: sleep 30\n    routePath: /app1/sub\n    codePath: app2\n    type: command\n",
+			want: []string{
+				"line 14: apps[1].name: duplicate name \"app1\", already used by apps[0]",
+				`line 16: apps[1].routePath: routePath "/app1/sub" conflicts with "/app1" of apps[0]`,
+			},
+		},
+		{
+			name:   "every error is reported",
+			config: strings.Replace(validTestConfig, "type: python", "type: ruby\n    colour: blue\n    idleTimeout: -1s", 1),
+			want: []string{
+				"line 14: field colour not found in type app.Config",
+				`line 13: apps[0].type: invalid type "ruby", expected one of python, nodejs, r, command`,
+				"line 15: apps[0].idleTimeout: idleTimeout must not be negative",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(test.config))
+			if len(test.want) == 0 {
+				if err != nil || config == nil {
+					t.Fatalf("ParseConfig: %v", err)
+				}
+				return
+			}
+			var validationErrors ValidationErrors
+			if !errors.As(err, &validationErrors) {
+				t.Fatalf("ParseConfig error = %v, want validation errors", err)
+			}
+			if config != nil {
+				t.Error("ParseConfig returned a config along with errors")
+			}
+			if want := strings.Join(test.want, "\n"); validationErrors.Error() != want {
+				t.Errorf("ParseConfig errors:\n%s\nwant:\n%s", validationErrors, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// venvHashFile is written into the venv once it is fully built. It holds the hash of
+// everything the venv was built from, see venvHash.
+const venvHashFile = ".multi-app-hash"
+
+// venvInputs are the files whose content decides what ends up in the venv.
+var venvInputs = []string{"requirements.txt", "pyproject.toml", "uv.lock", "poetry.lock", "Pipfile", "Pipfile.lock"}
+
+// pythonVersion returns the version of the interpreter the venv is built with.
+func (a *App) pythonVersion() (string, error) {
+	out, err := exec.Command(a.Python.InterpreterName(), "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("could not determine python version: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// venvHash hashes the python version, the dependency manager and the dependency files of
+// the app, so a venv built from the same inputs can be reused.
+func (a *App) venvHash() (string, error) {
+	version, err := a.pythonVersion()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(version + "\n"))
+	hash.Write([]byte(a.dependencyManager().String() + "\n"))
+	for _, name := range venvInputs {
+		content, err := os.ReadFile(filepath.Join(a.RootDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(name + "\n"))
+		hash.Write(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// venvUpToDate reports whether the existing venv was built from the given hash.
+func (a *App) venvUpToDate(hash string) bool {
+	stored, err := os.ReadFile(filepath.Join(a.pythonVenvPath().VenvDir, venvHashFile))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(stored)) == hash
+}
+
+func (a *App) writeVenvHash(hash string) error {
+	return os.WriteFile(filepath.Join(a.pythonVenvPath().VenvDir, venvHashFile), []byte(hash+"\n"), 0644)
+}
+
+// RequestRebuild makes the next start throw away the cached venv and build it again.
+func (a *App) RequestRebuild() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.rebuild = true
+}
+
+// takeRebuild returns whether a rebuild was requested and clears the request.
+func (a *App) takeRebuild() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	rebuild := a.rebuild
+	a.rebuild = false
+	return rebuild
+}
+
+// buildVenv creates the venv and installs the app's dependencies into it, unless a venv
+// built from the same python version and dependencies already exists.
+func (a *App) buildVenv() error {
+	rebuild := a.takeRebuild()
+	hash, err := a.venvHash()
+	if err != nil {
+		// without a hash we cannot tell if the venv is stale, so always build it
+		fmt.Println("Error hashing venv inputs", err)
+		hash = ""
+	}
+	if hash != "" && !rebuild && a.venvUpToDate(hash) {
+		fmt.Println("Reusing cached venv")
+		a.LogLines.Append(fmt.Sprintf("==> reusing cached venv %s", hash[:12]))
+		return nil
+	}
+
+	err = os.RemoveAll(a.pythonVenvPath().VenvDir)
+	if err != nil {
+		return err
+	}
+	err = a.makeVenv()
+	if err != nil {
+		fmt.Println("Error setting up venv")
+		return err
+	}
+	err = a.installDependencies()
+	if err != nil {
+		fmt.Println("Error installing dependencies")
+		return err
+	}
+	if hash == "" {
+		return nil
+	}
+	return a.writeVenvHash(hash)
+}
@@ -7,10 +7,11 @@ import requests
 import os
 
 from dataclasses import dataclass, field
+from typing import Optional
 from streamlit.web.server.websocket_headers import _get_websocket_headers
 
 PORT = os.environ.get("DATABRICKS_APP_PORT", "8000")
-MANAGEMENT_API_URL = f"http://0.0.0.0:{PORT}"
+MANAGEMENT_API_URL = os.environ.get("MULTI_APP_RELAY_URL", f"http://0.0.0.0:{PORT}")
 APPS_API_URL = f"{MANAGEMENT_API_URL}/apps"
 
 def generate_forwarded_url():
@@ -34,9 +35,18 @@ class AppTile:
     logs_url: str
     start_url: str
     stop_url: str
+    cancel_url: str
     tags: list[str] = field(default_factory=list)
     logo_url: str = "https://via.placeholder.com/400"
     status: str = "terminated"
+    last_exit_code: Optional[int] = None
+    last_error: Optional[str] = None
+    started_at: Optional[str] = None
+    stopped_at: Optional[str] = None
+    last_request_at: Optional[str] = None
+    memory_usage_mb: Optional[int] = None
+    queue_position: Optional[int] = None
+    queue_reason: Optional[str] = None
 
     @property
     def key(self):
@@ -52,6 +62,21 @@ class AppTile:
         result = resp.json()
         status_map = result.get("statuses", {})
         self.status = status_map.get(self.app_name, "terminated")
+        self.update_details(result.get("details", {}).get(self.app_name, {}))
+        self.update_queue(result.get("queue", []))
+
+    def update_queue(self, queue: list):
+        entry = next((entry for entry in queue if entry["name"] == self.app_name), {})
+        self.queue_position = entry.get("position")
+        self.queue_reason = entry.get("reason")
+
+    def update_details(self, details: dict):
+        self.last_exit_code = details.get("lastExitCode")
+        self.last_error = details.get("lastError")
+        self.started_at = details.get("startedAt")
+        self.stopped_at = details.get("stoppedAt")
+        self.last_request_at = details.get("lastRequestAt")
+        self.memory_usage_mb = details.get("memoryUsageMB")
 
     def start_app(self):
         resp = requests.post(self.start_url)
@@ -63,30 +88,38 @@ class AppTile:
         resp.raise_for_status()
         self.refresh_status()
 
+    def cancel_start(self):
+        resp = requests.post(self.cancel_url)
+        resp.raise_for_status()
+        self.refresh_status()
+
 def get_tiles():
     resp = requests.get(APPS_API_URL)
     resp.raise_for_status()
     result = resp.json()
     apps = result.get("cfg", {}).get("apps", [])
     status_map = result.get("statuses", {})
+    details_map = result.get("details", {})
     tiles = []
     for app in apps:
         meta = app.get("meta", {})
         route = app.get("routePath", "/")
-        tiles.append(
-            AppTile(
-                app_name=app["name"],
-                title=meta["title"],
-                description=meta["description"],
-                logs_url=generate_forwarded_url() + "/relay/" + route.lstrip("/").rstrip("/") + "/_logz",
-                launch_url=generate_forwarded_url() + "/relay/" + route.lstrip("/"),
-                start_url=MANAGEMENT_API_URL + "/" + route.lstrip("/").rstrip("/") + "/start",
-                stop_url=MANAGEMENT_API_URL + "/" + route.lstrip("/").rstrip("/") + "/kill",
-                tags=meta.get("tags", []),
-                logo_url=meta.get("logo_url", "https://via.placeholder.com/400"),
-                status=status_map.get(app["name"], "terminated")
-            )
+        tile = AppTile(
+            app_name=app["name"],
+            title=meta["title"],
+            description=meta["description"],
+            logs_url=generate_forwarded_url() + "/relay/" + route.lstrip("/").rstrip("/") + "/_logz",
+            launch_url=generate_forwarded_url() + "/relay/" + route.lstrip("/"),
+            start_url=MANAGEMENT_API_URL + "/" + route.lstrip("/").rstrip("/") + "/start",
+            stop_url=MANAGEMENT_API_URL + "/" + route.lstrip("/").rstrip("/") + "/kill",
+            cancel_url=MANAGEMENT_API_URL + "/" + route.lstrip("/").rstrip("/") + "/cancel",
+            tags=meta.get("tags", []),
+            logo_url=meta.get("logo_url", "https://via.placeholder.com/400"),
+            status=status_map.get(app["name"], "terminated")
         )
+        tile.update_details(details_map.get(app["name"], {}))
+        tile.update_queue(result.get("queue", []))
+        tiles.append(tile)
     return tiles
 
 
@@ -111,9 +144,9 @@ def start_app_api_call(tile: AppTile):
 
     tile.start_app()
 
-    for i in range(10):
+    for i in range(30):
         tile.refresh_status()
-        if tile.status == "running" or tile.status == "terminated":
+        if tile.status in ("ready", "unhealthy", "terminated", "failed", "queued"):
             break
         time.sleep(1)
 
@@ -127,7 +160,7 @@ def stop_app_api_call(tile: AppTile):
     tile.stop_app()
     for i in range(10):
         tile.refresh_status()
-        if tile.status == "terminated":
+        if tile.status in ("terminated", "failed"):
             break
         time.sleep(1)
 
@@ -150,10 +183,24 @@ def create_tile(tile: AppTile):
         app_status = server_state[app_status_key]
 
     st.write(f"App Status: {app_status}")
+    if tile.started_at:
+        st.caption(f"Started: {tile.started_at}" + (f" · Stopped: {tile.stopped_at}" if tile.stopped_at else "")
+                   + (f" · Last request: {tile.last_request_at}" if tile.last_request_at else "")
+                   + (f" · Memory: {tile.memory_usage_mb} MB" if tile.memory_usage_mb else ""))
+    if app_status == "failed" and tile.last_error:
+        st.error(f"{tile.last_error} (exit code {tile.last_exit_code})")
+    if app_status == "queued" and tile.queue_position:
+        st.info(f"Queued at position {tile.queue_position}: {tile.queue_reason}")
 
     col1, col2 = st.columns([1, 4], gap="small")
 
-    if app_status == "terminated":
+    if app_status == "queued":
+        if col1.button("Cancel", key=tile.key + "_cancel"):
+            tile.cancel_start()
+            with server_state_lock[app_status_key]:
+                server_state[app_status_key] = tile.status
+            col1.button("Run", key=tile.key + "_run", disabled=False)
+    elif app_status in ("terminated", "failed"):
         if col1.button("Run", key=tile.key + "_run"):
             with col2:
                 with st.spinner("Starting app..."):